@@ -0,0 +1,203 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "time"
+)
+
+// paused suspends checks of every feed, e.g. during upstream maintenance.
+// Feeds stay registered and keep their info meanwhile.
+var paused atomic.Bool
+
+// pausedAt is when the current pause started, guarded by mu.
+var pausedAt time.Time
+
+// pinHandler serves POST /admin/pin?url=URL&pinned=true|false.
+func pinHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    url, err := canonicalURL(r.URL.Query().Get("url"))
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error()))
+        return
+    }
+    pinned := true
+    if v := r.URL.Query().Get("pinned"); v != "" {
+        if pinned, err = strconv.ParseBool(v); err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            w.Write([]byte(fmt.Sprintf("Invalid pinned %q", v)))
+            return
+        }
+    }
+    mu.Lock()
+    opts, ok := options[url]
+    if ok {
+        opts.Pinned = pinned
+        options[url] = opts
+    }
+    mu.Unlock()
+    if !ok {
+        w.WriteHeader(http.StatusNotFound)
+        w.Write([]byte("feed is not monitored\n"))
+        return
+    }
+    log.Printf("%s pinned: %v", url, pinned)
+    if wantsJSON(r) {
+        writeJSON(w, http.StatusOK, map[string]bool{"pinned": pinned})
+        return
+    }
+    w.Write([]byte(fmt.Sprintf("%s pinned: %v\n", url, pinned)))
+}
+
+// pauseHandler serves POST /admin/pause and POST /admin/resume.
+func pauseHandler(pause bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            w.Header().Set("Allow", http.MethodPost)
+            w.WriteHeader(http.StatusMethodNotAllowed)
+            return
+        }
+        mu.Lock()
+        if paused.Swap(pause) != pause {
+            if pause {
+                pausedAt = clock.Now()
+                log.Println("monitoring paused")
+            } else {
+                // failures and idleness don't accrue while paused
+                pausedFor := since(pausedAt)
+                for url, fi := range info {
+                    if !fi.FailureSince.IsZero() {
+                        fi.FailureSince = fi.FailureSince.Add(pausedFor)
+                        info[url] = fi
+                    }
+                }
+                now := clock.Now()
+                for url, requested := range updaters {
+                    if requested = requested.Add(pausedFor); requested.After(now) {
+                        requested = now
+                    }
+                    updaters[url] = requested
+                }
+                log.Printf("monitoring resumed after %v\n", pausedFor.Round(time.Second))
+            }
+        }
+        mu.Unlock()
+        if wantsJSON(r) {
+            writeJSON(w, http.StatusOK, map[string]bool{"paused": pause})
+            return
+        }
+        if pause {
+            w.Write([]byte("monitoring paused\n"))
+        } else {
+            w.Write([]byte("monitoring resumed\n"))
+        }
+    }
+}
+
+// resetHandler serves POST /admin/feeds/reset?url=URL: the failure state,
+// backoff and circuit breaker of the feed are cleared and it's checked at
+// once. The answer is the feed info after the check.
+func resetHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    url, err := canonicalURL(r.URL.Query().Get("url"))
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error()))
+        return
+    }
+    if paused.Load() {
+        w.WriteHeader(http.StatusConflict)
+        w.Write([]byte("monitoring is paused\n"))
+        return
+    }
+    done, ok := requestCheck(r.Context(), url, true)
+    if !ok {
+        w.WriteHeader(http.StatusNotFound)
+        w.Write([]byte("feed is not monitored\n"))
+        return
+    }
+    select {
+    case <-done:
+    case <-r.Context().Done():
+        return
+    }
+    mu.RLock()
+    feed, counted := info[url]
+    mu.RUnlock()
+    if !counted {
+        w.WriteHeader(http.StatusAccepted)
+        w.Write([]byte("counting vacancies\n"))
+        return
+    }
+    if wantsJSON(r) {
+        writeJSON(w, http.StatusOK, newFeedInfoResponse(url, feed))
+        return
+    }
+    w.Write([]byte(fmt.Sprintf("%s reset, status: %s, vacanciesCount: %d\n", url, feed.status(), feed.VacanciesCount)))
+}
+
+// optionsHandler serves POST /admin/feeds/options?url=URL&options... which
+// sets the options given as on registration over the current ones of a
+// monitored feed, the others are kept. A change of counting options
+// recounts the feed at once.
+func optionsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    url, err := canonicalURL(r.URL.Query().Get("url"))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, apiError{Code: "invalid_url", Message: err.Error()})
+        return
+    }
+    mu.Lock()
+    old, ok := options[url]
+    opts, err := applyFeedOptions(old, r.URL.Query())
+    recount := ok && err == nil && opts.countingChanged(old)
+    if ok && err == nil {
+        options[url] = opts
+    }
+    if fi, counted := info[url]; recount && counted {
+        // the cached count is of the old options
+        fi.RecountRequested = true
+        info[url] = fi
+    }
+    mu.Unlock()
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, apiError{Code: "invalid_options", Message: err.Error()})
+        return
+    }
+    if !ok {
+        w.WriteHeader(http.StatusNotFound)
+        w.Write([]byte("feed is not monitored\n"))
+        return
+    }
+    if recount {
+        log.Printf("%s counting options changed - recount", url)
+        // a running check leaves the recount requested, don't wait for it
+        ctx, cancel := context.WithTimeout(context.Background(), cfg().PollInterval.Duration)
+        go func() {
+            defer cancel()
+            requestCheck(ctx, url, false)
+        }()
+    }
+    if wantsJSON(r) {
+        writeJSON(w, http.StatusOK, map[string]interface{}{"options": opts, "recount": recount})
+        return
+    }
+    w.Write([]byte(fmt.Sprintf("%s options updated, recount: %v\n", url, recount)))
+}
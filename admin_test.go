@@ -0,0 +1,156 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "sync/atomic"
+    "testing"
+)
+
+func TestPauseHandler(t *testing.T) {
+    t.Cleanup(func() { paused.Store(false) })
+    prevKey := *apiKey
+    *apiKey = "secret"
+    t.Cleanup(func() { *apiKey = prevKey })
+    pause, resume := requireAdminKey(pauseHandler(true)), requireAdminKey(pauseHandler(false))
+
+    w := httptest.NewRecorder()
+    pause(w, httptest.NewRequest(http.MethodPost, "/admin/pause", nil))
+    if w.Code != http.StatusUnauthorized || paused.Load() {
+        t.Fatalf("pause without the key answered %d, paused %v", w.Code, paused.Load())
+    }
+    for _, step := range []struct {
+        handler http.HandlerFunc
+        paused  bool
+    }{{pause, true}, {pause, true}, {resume, false}} {
+        r := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+        r.Header.Set("X-API-Key", "secret")
+        w := httptest.NewRecorder()
+        step.handler(w, r)
+        if w.Code != http.StatusOK || paused.Load() != step.paused {
+            t.Errorf("answered %d, paused %v, expected 200 and paused %v", w.Code, paused.Load(), step.paused)
+        }
+    }
+}
+
+// setFeedOptions sets options of a monitored feed with the options handler
+// and tells if they recount it.
+func setFeedOptions(t *testing.T, url, query string) (recount bool) {
+    t.Helper()
+    r := httptest.NewRequest(http.MethodPost, "/admin/feeds/options?url="+url+"&"+query, nil)
+    r.Header.Set("Accept", "application/json")
+    w := httptest.NewRecorder()
+    optionsHandler(w, r)
+    var resp struct {
+        Recount bool `json:"recount"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil {
+        t.Fatalf("%s answered %d: %s", query, w.Code, w.Body)
+    }
+    return resp.Recount
+}
+
+func TestOptionsHandlerMergesAndRecounts(t *testing.T) {
+    srv := httptest.NewServer(feedHandler(gzipped(t, vacanciesXML(5))))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    monitorForTest(t, url, FeedOptions{Pinned: true, Tags: []string{"core"}})
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+    setOptions := func(query string) bool { return setFeedOptions(t, url, query) }
+    feed := func() (FeedOptions, FeedInfo) {
+        mu.RLock()
+        defer mu.RUnlock()
+        return options[url], info[url]
+    }
+
+    // only the first vacancy has id 1
+    if !setOptions("attr=id%3D1") {
+        t.Error("a counting option change doesn't recount")
+    }
+    opts, fi := feed()
+    if !fi.RecountRequested {
+        t.Error("the cached count isn't invalidated")
+    }
+    if !opts.Pinned || !reflect.DeepEqual(opts.Tags, []string{"core"}) {
+        t.Errorf("options are %+v, expected pinning and tags kept", opts)
+    }
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+    if _, fi = feed(); fi.VacanciesCount != 1 || fi.CacheMisses != 2 || fi.RecountRequested {
+        t.Errorf("after the recount counted %d in %d counts, recount requested %v, expected 1 in 2 counts",
+            fi.VacanciesCount, fi.CacheMisses, fi.RecountRequested)
+    }
+
+    if setOptions("tag=reports") {
+        t.Error("a tags change recounts")
+    }
+    opts, fi = feed()
+    if fi.RecountRequested {
+        t.Error("a tags change invalidates the cached count")
+    }
+    if opts.Attr != "id=1" || !opts.Pinned || !reflect.DeepEqual(opts.Tags, []string{"reports"}) {
+        t.Errorf("options are %+v, expected the attribute rule and pinning kept, tags replaced", opts)
+    }
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+    if _, fi = feed(); fi.VacanciesCount != 1 || fi.CacheMisses != 2 || fi.CacheHits != 1 {
+        t.Errorf("after the tags change counted %d in %d counts, %d cache hits, expected the cached 1", fi.VacanciesCount, fi.CacheMisses, fi.CacheHits)
+    }
+}
+
+func TestOptionsChangedDuringCount(t *testing.T) {
+    var block atomic.Bool
+    started, release := make(chan struct{}, 1), make(chan struct{})
+    archive := feedHandler(gzipped(t, vacanciesXML(5)))
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.RawQuery != "stat" && block.Load() {
+            started <- struct{}{}
+            <-release
+        }
+        archive(w, r)
+    }))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    monitorForTest(t, url, FeedOptions{})
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+
+    // the recount of the first change is running when the second comes
+    setFeedOptions(t, url, "attr=id%3D1")
+    block.Store(true)
+    done := make(chan error)
+    go func() { done <- updateInfoIfNeed(context.Background(), url, info) }()
+    <-started
+    if !setFeedOptions(t, url, "attr=id%3D2") {
+        t.Error("a counting option change doesn't recount")
+    }
+    close(release)
+    if err := <-done; err != nil {
+        t.Fatal(err)
+    }
+    block.Store(false)
+    mu.RLock()
+    fi := info[url]
+    mu.RUnlock()
+    if !fi.RecountRequested {
+        t.Fatal("the count by the old options cleared the requested recount")
+    }
+
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+    mu.RLock()
+    fi = info[url]
+    mu.RUnlock()
+    if fi.CacheMisses != 3 || fi.RecountRequested {
+        t.Errorf("counted %d times, recount requested %v, expected 3 counts", fi.CacheMisses, fi.RecountRequested)
+    }
+}
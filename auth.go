@@ -0,0 +1,73 @@
+package main
+
+import (
+    "crypto/subtle"
+    "flag"
+    "net/http"
+    "strings"
+)
+
+var (
+    apiKey = flag.String("api-key", "", "key required to register feeds and manage monitoring, "+
+        "sent as 'Authorization: Bearer KEY' or 'X-API-Key: KEY'; empty - no authentication")
+    apiKeyReads = flag.Bool("api-key-reads", false, "require -api-key for reading info about monitored feeds too")
+)
+
+// authorized tells if the request carries the api key, always true when no
+// key is configured.
+func authorized(r *http.Request) bool {
+    if *apiKey == "" {
+        return true
+    }
+    key := r.Header.Get("X-API-Key")
+    if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+        key = strings.TrimPrefix(auth, "Bearer ")
+    }
+    return subtle.ConstantTimeCompare([]byte(key), []byte(*apiKey)) == 1
+}
+
+// authorizedRead is authorized for endpoints only reading monitored feeds.
+func authorizedRead(r *http.Request) bool {
+    return !*apiKeyReads || authorized(r)
+}
+
+func unauthorized(w http.ResponseWriter) {
+    w.Header().Set("WWW-Authenticate", `Bearer realm="feed-monitoring"`)
+    w.WriteHeader(http.StatusUnauthorized)
+    w.Write([]byte("api key required\n"))
+}
+
+// requireAPIKey guards management endpoints.
+func requireAPIKey(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !authorized(r) {
+            unauthorized(w)
+            return
+        }
+        h(w, r)
+    }
+}
+
+// requireAdminKey guards destructive management endpoints, they are refused
+// while no key is configured.
+func requireAdminKey(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if *apiKey == "" {
+            w.WriteHeader(http.StatusForbidden)
+            w.Write([]byte("-api-key is required for this endpoint\n"))
+            return
+        }
+        requireAPIKey(h)(w, r)
+    }
+}
+
+// requireReadKey guards endpoints reading monitored feeds.
+func requireReadKey(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !authorizedRead(r) {
+            unauthorized(w)
+            return
+        }
+        h(w, r)
+    }
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "flag"
+    "log"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+var maxAuxMemory = flag.Int64("max-aux-memory", 0, "soft cap in bytes of the failure episodes, count duration windows and raw stats kept of all feeds, 0 - none")
+
+// auxMemoryInterval is how often the cap is enforced, it's soft in between.
+const auxMemoryInterval = 10 * time.Second
+
+// Approximate sizes of the auxiliary data, strings are counted by length on
+// top of them.
+const (
+    episodeBytes   = 64
+    windowBytes    = 48
+    sampleBytes    = 8
+    tallyBytes     = reliabilityBuckets * 24
+    statFieldBytes = 32
+)
+
+// trimmedStatBytes is the raw stat kept of a feed under pressure.
+const trimmedStatBytes = 256
+
+// auxTrimmed are the episodes, samples and stats trimmed to the cap.
+var auxTrimmed atomic.Int64
+
+func episodeSize(e failureEpisode) int64 {
+    return episodeBytes + int64(len(e.LastError))
+}
+
+func windowSize(w *durationWindow) int64 {
+    return windowBytes + sampleBytes*int64(len(w.samples))
+}
+
+func statSize(fi FeedInfo) int64 {
+    size := int64(len(fi.Stat))
+    for k, v := range fi.StatFields {
+        size += statFieldBytes + int64(len(k)+len(v))
+    }
+    return size
+}
+
+// auxMemory estimates the auxiliary data of all feeds, mu must be held.
+func auxMemory() int64 {
+    var size int64
+    for _, episodes := range failureHistory {
+        for _, e := range episodes {
+            size += episodeSize(e)
+        }
+    }
+    for _, w := range countDurations {
+        size += windowSize(w)
+    }
+    size += tallyBytes * int64(len(checkTallies))
+    for _, fi := range info {
+        size += statSize(fi)
+    }
+    return size
+}
+
+// keepNewest drops all but the n most recent samples of the window.
+func (w *durationWindow) keepNewest(n int) {
+    ordered := append(append([]time.Duration(nil), w.samples[w.next:]...), w.samples[:w.next]...)
+    if len(ordered) > n {
+        ordered = ordered[len(ordered)-n:]
+    }
+    w.samples, w.next = ordered, 0
+}
+
+// trimAuxMemory trims the auxiliary data to limit: ended failure episodes
+// go first, the oldest ended first, then the count duration windows are
+// halved, the oldest samples dropped, and the raw stats of the largest are
+// cut. Open episodes, the last sample of a window and tallies are kept. mu
+// must be held.
+func trimAuxMemory(limit int64) (usage int64, trimmed int) {
+    usage = auxMemory()
+    if usage <= limit {
+        return usage, 0
+    }
+
+    type ended struct {
+        url string
+        end time.Time
+    }
+    var episodes []ended
+    for url, history := range failureHistory {
+        for _, e := range history {
+            if e.End != nil {
+                episodes = append(episodes, ended{url, *e.End})
+            }
+        }
+    }
+    sort.Slice(episodes, func(i, j int) bool { return episodes[i].end.Before(episodes[j].end) })
+    for _, e := range episodes {
+        if usage <= limit {
+            return usage, trimmed
+        }
+        // the ended episodes of a feed precede its open one
+        history := failureHistory[e.url]
+        usage -= episodeSize(history[0])
+        failureHistory[e.url] = history[1:]
+        trimmed++
+    }
+
+    for usage > limit {
+        halved := false
+        for _, w := range countDurations {
+            if usage <= limit {
+                break
+            }
+            if n := len(w.samples); n > 1 {
+                usage -= sampleBytes * int64(n-n/2)
+                trimmed += n - n/2
+                w.keepNewest(n / 2)
+                halved = true
+            }
+        }
+        if !halved {
+            break
+        }
+    }
+
+    urls := make([]string, 0, len(info))
+    for url, fi := range info {
+        if statSize(fi) > trimmedStatBytes {
+            urls = append(urls, url)
+        }
+    }
+    sort.Slice(urls, func(i, j int) bool { return statSize(info[urls[i]]) > statSize(info[urls[j]]) })
+    for _, url := range urls {
+        if usage <= limit {
+            break
+        }
+        fi := info[url]
+        usage -= statSize(fi)
+        fi.Stat = strings.ToValidUTF8(fi.Stat[:min(len(fi.Stat), trimmedStatBytes)], "")
+        fi.StatFields = nil
+        usage += statSize(fi)
+        info[url] = fi
+        trimmed++
+    }
+    return usage, trimmed
+}
+
+// capAuxMemory enforces -max-aux-memory every auxMemoryInterval.
+func capAuxMemory() {
+    ticker := clock.NewTicker(auxMemoryInterval)
+    defer ticker.Stop()
+    for range ticker.C() {
+        mu.Lock()
+        usage, trimmed := trimAuxMemory(*maxAuxMemory)
+        mu.Unlock()
+        if trimmed > 0 {
+            auxTrimmed.Add(int64(trimmed))
+            log.Printf("trimmed %d auxiliary entries to %d bytes, the cap is %d", trimmed, usage, *maxAuxMemory)
+        }
+    }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// useAuxData replaces the auxiliary data of all feeds for the test.
+func useAuxData(t *testing.T, windows map[string]*durationWindow, feeds map[string]FeedInfo) {
+    mu.Lock()
+    prevWindows, prevHistory, prevTallies, prevInfo := countDurations, failureHistory, checkTallies, info
+    countDurations, info = windows, feeds
+    failureHistory, checkTallies = make(map[string][]failureEpisode), make(map[string]*checkTally)
+    mu.Unlock()
+    t.Cleanup(func() {
+        mu.Lock()
+        countDurations, failureHistory, checkTallies, info = prevWindows, prevHistory, prevTallies, prevInfo
+        mu.Unlock()
+    })
+}
+
+func windowOf(n int) *durationWindow {
+    return &durationWindow{samples: make([]time.Duration, n)}
+}
+
+func TestTrimAuxMemoryStopsAtLimit(t *testing.T) {
+    stat := strings.Repeat("x", 4*trimmedStatBytes)
+    useAuxData(t,
+        map[string]*durationWindow{"a": windowOf(8), "b": windowOf(8)},
+        map[string]FeedInfo{"a": {Stat: stat}})
+    mu.Lock()
+    defer mu.Unlock()
+
+    // halving one window is enough, the other one and the stat are kept
+    limit := auxMemory() - 4*sampleBytes
+    usage, trimmed := trimAuxMemory(limit)
+    if usage != limit || trimmed != 4 {
+        t.Errorf("trimmed %d entries to %d bytes, expected 4 to %d", trimmed, usage, limit)
+    }
+    if n := len(countDurations["a"].samples) + len(countDurations["b"].samples); n != 12 {
+        t.Errorf("kept %d samples, expected 12", n)
+    }
+    if info["a"].Stat != stat {
+        t.Errorf("stat cut to %d bytes while the windows were enough", len(info["a"].Stat))
+    }
+
+    // the windows can't go below a sample, so the stat is cut
+    usage, _ = trimAuxMemory(0)
+    if len(countDurations["a"].samples) != 1 || len(countDurations["b"].samples) != 1 {
+        t.Errorf("kept %d and %d samples, expected 1 and 1", len(countDurations["a"].samples), len(countDurations["b"].samples))
+    }
+    if len(info["a"].Stat) != trimmedStatBytes {
+        t.Errorf("stat cut to %d bytes, expected %d", len(info["a"].Stat), trimmedStatBytes)
+    }
+    if usage != auxMemory() {
+        t.Errorf("trimmed to %d bytes, the estimate is %d", usage, auxMemory())
+    }
+}
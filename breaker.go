@@ -0,0 +1,48 @@
+package main
+
+import (
+    "time"
+)
+
+type breakerState string
+
+const (
+    breakerClosed   breakerState = "closed"
+    breakerOpen     breakerState = "open"
+    breakerHalfOpen breakerState = "half-open"
+)
+
+// breaker suspends checks of a feed after BreakerThreshold consecutive
+// failures for BreakerCooldown, then a single probe check closes it again or
+// re-opens it. It's used by the feed's poller only.
+type breaker struct {
+    state     breakerState
+    failures  int
+    openUntil time.Time
+}
+
+// allow tells if a check may run now.
+func (b *breaker) allow(now time.Time) bool {
+    if b.state != breakerOpen {
+        return true
+    }
+    if now.Before(b.openUntil) {
+        return false
+    }
+    b.state = breakerHalfOpen
+    return true
+}
+
+// record accounts the result of a check.
+func (b *breaker) record(err error, now time.Time) {
+    if err == nil {
+        b.state, b.failures = breakerClosed, 0
+        return
+    }
+    b.failures++
+    threshold := cfg().BreakerThreshold
+    if b.state == breakerHalfOpen || threshold > 0 && b.failures >= threshold {
+        b.state = breakerOpen
+        b.openUntil = now.Add(cfg().BreakerCooldown.Duration)
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// canonicalURL normalizes a feed url so that equivalent spellings of it key
+// the same feed: scheme and host are lowercased, default ports, fragment and
+// a trailing slash are dropped, dot segments resolved, query parameters are
+// sorted. Path case is kept as it's significant. A stat url pasted instead
+// of the feed one is stripped of its ?stat, other queries having stat are
+// rejected.
+func canonicalURL(raw string) (string, error) {
+    u, err := url.Parse(strings.TrimSpace(raw))
+    if err != nil {
+        return "", fmt.Errorf("Invalid feed url %q: %v", raw, err)
+    }
+    u.Scheme = strings.ToLower(u.Scheme)
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return "", fmt.Errorf("Invalid feed url %q: expected http or https scheme", raw)
+    }
+    if u.Host == "" {
+        return "", fmt.Errorf("Invalid feed url %q: no host", raw)
+    }
+    host, port := strings.ToLower(u.Hostname()), u.Port()
+    if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+        port = ""
+    }
+    if strings.Contains(host, ":") {
+        host = "[" + host + "]"
+    }
+    if port != "" {
+        host += ":" + port
+    }
+    u.Host = host
+    u.Fragment, u.RawFragment = "", ""
+    if u.Path == "" {
+        u.Path = "/"
+    }
+    // resolving against nothing removes . and .. as in a relative link
+    u = u.ResolveReference(&url.URL{})
+    if len(u.Path) > 1 {
+        u.Path = strings.TrimSuffix(u.Path, "/")
+        u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+    }
+    if u.RawQuery != "" {
+        q := u.Query()
+        if _, ok := q["stat"]; ok {
+            if len(q) > 1 || q.Get("stat") != "" {
+                return "", fmt.Errorf("Invalid feed url %q: stat query is added to feed urls, pass the feed url itself", raw)
+            }
+            delete(q, "stat")
+        }
+        u.RawQuery = q.Encode()
+        u.ForceQuery = false
+    }
+    return u.String(), nil
+}
+
+// canonicalPrefix canonicalizes a url prefix as canonicalURL does the urls
+// it's matched with. A prefix ending in the host may be of a partial one, so
+// no path is added to it, and a trailing slash is kept.
+func canonicalPrefix(raw string) (string, error) {
+    prefix, err := canonicalURL(raw)
+    if err != nil {
+        return "", err
+    }
+    u, _ := url.Parse(strings.TrimSpace(raw))
+    switch {
+    case u.Path == "" && u.RawQuery == "":
+        prefix = strings.TrimSuffix(prefix, "/")
+    case strings.HasSuffix(u.Path, "/") && !strings.HasSuffix(prefix, "/"):
+        prefix += "/"
+    }
+    return prefix, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestCanonicalURL(t *testing.T) {
+    tests := []struct {
+        raw, expected string
+    }{
+        {"http://example.com/feed.xml.gz", "http://example.com/feed.xml.gz"},
+        {"HTTP://Example.COM/Feed.xml.gz", "http://example.com/Feed.xml.gz"},
+        {"http://example.com:80/feed.xml.gz", "http://example.com/feed.xml.gz"},
+        {"https://example.com:443/feed.xml.gz", "https://example.com/feed.xml.gz"},
+        {"http://example.com:8080/feed.xml.gz", "http://example.com:8080/feed.xml.gz"},
+        {"https://example.com:80/feed.xml.gz", "https://example.com:80/feed.xml.gz"},
+        {"http://example.com/feeds/", "http://example.com/feeds"},
+        {"http://example.com", "http://example.com/"},
+        {"http://example.com/", "http://example.com/"},
+        {"http://example.com/a/./feed.xml.gz", "http://example.com/a/feed.xml.gz"},
+        {"http://example.com/a/b/../feed.xml.gz", "http://example.com/a/feed.xml.gz"},
+        {"http://example.com/a/b/..", "http://example.com/a"},
+        {"http://example.com/../feed.xml.gz", "http://example.com/feed.xml.gz"},
+        {"http://example.com/feed?b=2&a=1", "http://example.com/feed?a=1&b=2"},
+        {"http://example.com/feed#top", "http://example.com/feed"},
+        {" http://[::1]:80/feed ", "http://[::1]/feed"},
+    }
+    for _, test := range tests {
+        got, err := canonicalURL(test.raw)
+        if err != nil {
+            t.Errorf("canonicalURL(%q) failed: %v", test.raw, err)
+            continue
+        }
+        if got != test.expected {
+            t.Errorf("canonicalURL(%q) = %q, expected %q", test.raw, got, test.expected)
+        }
+    }
+}
+
+func TestCanonicalURLRejects(t *testing.T) {
+    for _, raw := range []string{
+        "ftp://example.com/feed",
+        "http:///feed",
+        "example.com/feed",
+    } {
+        if got, err := canonicalURL(raw); err == nil {
+            t.Errorf("canonicalURL(%q) = %q, expected an error", raw, got)
+        }
+    }
+}
+
+func TestCanonicalPrefix(t *testing.T) {
+    tests := []struct {
+        raw, expected string
+    }{
+        {"http://example.com/", "http://example.com/"},
+        {"HTTP://Example.COM:80/", "http://example.com/"},
+        {"https://exa", "https://exa"},
+        {"http://example.com/feeds/", "http://example.com/feeds/"},
+        {"http://example.com/feeds", "http://example.com/feeds"},
+        {"http://example.com/a/../feeds/", "http://example.com/feeds/"},
+    }
+    for _, test := range tests {
+        got, err := canonicalPrefix(test.raw)
+        if err != nil {
+            t.Errorf("canonicalPrefix(%q) failed: %v", test.raw, err)
+            continue
+        }
+        if got != test.expected {
+            t.Errorf("canonicalPrefix(%q) = %q, expected %q", test.raw, got, test.expected)
+        }
+    }
+    if _, err := canonicalPrefix("http"); err == nil {
+        t.Error("a prefix without a host is accepted")
+    }
+}
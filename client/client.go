@@ -0,0 +1,467 @@
+// Package client is a Go client of the feed monitoring service.
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// FeedInfo is the state of a monitored feed as returned by /feedinfo.
+type FeedInfo struct {
+    URL          string     `json:"url"`
+    Status       string     `json:"status"`
+    RegisteredAt *time.Time `json:"registeredAt,omitempty"`
+    Stat         string     `json:"stat,omitempty"`
+    SizeText     string     `json:"sizeText,omitempty"`
+    SizeBytes    int64      `json:"sizeBytes,omitempty"`
+    // VacanciesCount is nil while the feed is being counted for the first time.
+    VacanciesCount *int64     `json:"vacanciesCount"`
+    // RawVacanciesCount includes the elements excluded by the attribute
+    // rule, nil unless some were.
+    RawVacanciesCount *int64 `json:"rawVacanciesCount,omitempty"`
+    // CountDelta is the change since the previous count, CountDeltaPerHour
+    // its rate, nil before the second count.
+    CountDelta        *int64   `json:"countDelta,omitempty"`
+    CountDeltaPerHour *float64 `json:"countDeltaPerHour,omitempty"`
+    // Estimated marks VacanciesCount extrapolated from a sample.
+    Estimated      bool       `json:"estimated,omitempty"`
+    // LivenessOnly feeds aren't counted, VacanciesCount is nil.
+    LivenessOnly   bool       `json:"livenessOnly,omitempty"`
+    TrailerCorrupt bool       `json:"trailerCorrupt,omitempty"`
+    GeneratedAt    *time.Time `json:"generatedAt,omitempty"`
+    UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+    CountedAt      *time.Time `json:"countedAt,omitempty"`
+    FailureSince   *time.Time `json:"failureSince,omitempty"`
+    FailureKind    string     `json:"failureKind,omitempty"`
+    // LastError is the message of the last failed check at LastErrorAt.
+    LastError      string     `json:"lastError,omitempty"`
+    LastErrorAt    *time.Time `json:"lastErrorAt,omitempty"`
+    Breaker        string     `json:"breaker,omitempty"`
+    // FailingFor is set, in seconds, when the server serves the last good
+    // info of a failed feed.
+    FailingFor     int64      `json:"failingForSeconds,omitempty"`
+    Error          string     `json:"error,omitempty"`
+    Refreshing     bool       `json:"refreshing"`
+    Paused         bool       `json:"paused,omitempty"`
+    // Stale is set when the info is older than the requested max age.
+    Stale          bool       `json:"stale,omitempty"`
+    PartialCount   int64      `json:"partialCount,omitempty"`
+    PartialBytes   int64      `json:"partialBytes,omitempty"`
+
+    DownloadedBytes    int64 `json:"downloadedBytes,omitempty"`
+    SizeBudgetExceeded bool  `json:"sizeBudgetExceeded,omitempty"`
+    // Chunked is set when the archive response didn't tell its length.
+    Chunked bool `json:"chunked,omitempty"`
+
+    // Parts are the counts of the parts of an index feed, summed in
+    // VacanciesCount. PartsFailed of them failed and aren't.
+    Parts       []IndexPart `json:"parts,omitempty"`
+    PartsFailed int         `json:"partsFailed,omitempty"`
+
+    // Pages are counted of a paged feed, PageCycle is set when their next
+    // links lead back, PagesTruncated when there are too many.
+    Pages          int  `json:"pages,omitempty"`
+    PageCycle      bool `json:"pageCycle,omitempty"`
+    PagesTruncated bool `json:"pagesTruncated,omitempty"`
+
+    // AvgThroughput and PeakThroughput are the rates the decompressed feed
+    // was parsed at, bytes per second.
+    AvgThroughput  float64 `json:"avgThroughput,omitempty"`
+    PeakThroughput float64 `json:"peakThroughput,omitempty"`
+
+    ContentHash    string     `json:"contentHash,omitempty"`
+    UnchangedSince *time.Time `json:"unchangedSince,omitempty"`
+    Frozen         bool       `json:"frozen,omitempty"`
+
+    ContentLanguage string `json:"contentLanguage,omitempty"`
+
+    CacheHits   int64 `json:"cacheHits"`
+    CacheMisses int64 `json:"cacheMisses"`
+
+    VerifiedCount *int64 `json:"verifiedCount,omitempty"`
+    CountMismatch bool   `json:"countMismatch,omitempty"`
+
+    StatHost     string `json:"statHost,omitempty"`
+    ArchiveHost  string `json:"archiveHost,omitempty"`
+    HostMismatch bool   `json:"hostMismatch,omitempty"`
+
+    // Warnings are the validation classes which warned since the count.
+    Warnings []string `json:"warnings,omitempty"`
+
+    // StatFields are the "key:value" lines of the stat, StatCount is the
+    // vacancies count of a stat telling it.
+    StatFields map[string]string `json:"statFields,omitempty"`
+    StatCount  *int64            `json:"statCount,omitempty"`
+
+    // Failures are the recent failure episodes, returned when requested
+    // with RegisterOptions.Failures.
+    Failures []FailureEpisode `json:"failures,omitempty"`
+}
+
+// FailureEpisode is a run of failed checks of a feed, End is nil while it
+// lasts.
+type FailureEpisode struct {
+    Start     time.Time  `json:"start"`
+    End       *time.Time `json:"end,omitempty"`
+    Kind      string     `json:"kind"`
+    LastError string     `json:"lastError"`
+}
+
+// IndexPart is the count of a part of an index feed, Error is set if it
+// failed.
+type IndexPart struct {
+    URL            string `json:"url"`
+    VacanciesCount int64  `json:"vacanciesCount"`
+    Error          string `json:"error,omitempty"`
+}
+
+// Counting tells if the first count of the feed isn't done yet.
+func (fi *FeedInfo) Counting() bool {
+    return fi.Status == "counting" || fi.Status == "pending"
+}
+
+// Feed is a monitored feed as listed by /feeds.
+type Feed struct {
+    URL             string     `json:"url"`
+    Status          string     `json:"status"`
+    SizeText        string     `json:"sizeText"`
+    SizeBytes       int64      `json:"sizeBytes"`
+    VacanciesCount  int64      `json:"vacanciesCount"`
+    Estimated       bool       `json:"estimated,omitempty"`
+    LivenessOnly    bool       `json:"livenessOnly,omitempty"`
+    PartsFailed     int        `json:"partsFailed,omitempty"`
+    CountDuration   float64    `json:"countDurationSeconds"`
+    // CountDelta is the change since the previous count, CountDeltaPerHour
+    // its rate.
+    CountDelta        *int64   `json:"countDelta,omitempty"`
+    CountDeltaPerHour *float64 `json:"countDeltaPerHour,omitempty"`
+    // CountDurationP50 and others are quantiles of the recent counts.
+    CountDurationP50 float64 `json:"countDurationP50Seconds"`
+    CountDurationP95 float64 `json:"countDurationP95Seconds"`
+    CountDurationP99 float64 `json:"countDurationP99Seconds"`
+    // Reliability is the percentage of successful checks within the
+    // reliability window, nil before any.
+    Reliability  *float64 `json:"reliability,omitempty"`
+    ChecksOK     int64    `json:"checksOK"`
+    ChecksFailed int64    `json:"checksFailed"`
+    // Timeout is the effective request timeout of the feed, 0 - none.
+    Timeout float64 `json:"timeoutSeconds"`
+    LastRequestedAt *time.Time `json:"lastRequestedAt,omitempty"`
+    UpdatedAt       *time.Time `json:"updatedAt,omitempty"`
+    CountedAt       *time.Time `json:"countedAt,omitempty"`
+    GeneratedAt     *time.Time `json:"generatedAt,omitempty"`
+    FailureSince    *time.Time `json:"failureSince,omitempty"`
+    FailureKind     string     `json:"failureKind,omitempty"`
+    LastError       string     `json:"lastError,omitempty"`
+    LastErrorAt     *time.Time `json:"lastErrorAt,omitempty"`
+    Tags            []string   `json:"tags,omitempty"`
+    Breaker         string     `json:"breaker,omitempty"`
+    Frozen          bool       `json:"frozen,omitempty"`
+    Pinned          bool       `json:"pinned,omitempty"`
+    Warnings        []string   `json:"warnings,omitempty"`
+}
+
+// ErrFeedNotAlive is returned when a feed to register doesn't answer its stat.
+var ErrFeedNotAlive = errors.New("feed is not alive")
+
+// LimitError is returned when no more feeds can be monitored.
+type LimitError struct {
+    // Monitored are the feeds monitored at the moment.
+    Monitored []string
+}
+
+func (e *LimitError) Error() string {
+    return fmt.Sprintf("feeds limit is exhausted (%d feeds monitored)", len(e.Monitored))
+}
+
+// FailedError is returned when the info about a feed couldn't be updated
+// for longer than the service failure timeout. Info is the last known one.
+type FailedError struct {
+    Info *FeedInfo
+}
+
+func (e *FailedError) Error() string {
+    return fmt.Sprintf("feed %s failed: %s", e.Info.URL, e.Info.Error)
+}
+
+// StatusError is any other unexpected response.
+type StatusError struct {
+    Code int
+    Body string
+}
+
+func (e *StatusError) Error() string {
+    return fmt.Sprintf("unexpected status %d: %s", e.Code, e.Body)
+}
+
+// Client talks to a feed monitoring service.
+type Client struct {
+    // BaseURL is the service address, e.g. http://feed-monitoring:8080.
+    BaseURL string
+    // HTTPClient is used for requests, http.DefaultClient if nil.
+    HTTPClient *http.Client
+    // APIKey is sent when set, see -api-key of the service.
+    APIKey string
+}
+
+// New returns a client of the service at baseURL.
+func New(baseURL string) *Client {
+    return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// RegisterOptions are the feed options applied when monitoring starts, they
+// are ignored for feeds monitored already.
+type RegisterOptions struct {
+    Element         string
+    // Parent counts Element only directly inside it, Attr only the ones
+    // passing the attribute rule, e.g. status!=archived.
+    Parent          string
+    Attr            string
+    Root            string
+    MaxSize         int64
+    AbortOverBudget bool
+    Verify          bool
+    SizeFallback    bool
+    // LivenessOnly checks the feed is up without counting it, ProbeArchive
+    // checks the archive head too.
+    LivenessOnly bool
+    ProbeArchive bool
+    // Index registers an index listing the archives of the feed parts,
+    // Paged a feed which pages link to the next ones.
+    Index           bool
+    Paged           bool
+    SampleBytes     int64
+    // Timeout overrides the service request timeout for the feed.
+    Timeout time.Duration
+    // TolerateCorruptTrailer accepts counts of archives with a broken gzip
+    // trailer after the complete feed.
+    TolerateCorruptTrailer bool
+    Headers         map[string]string
+    // Cookies keeps the cookies set during a check of the feed, e.g. a
+    // session the stat sets for the archive.
+    Cookies bool
+    Proxy           string
+    Language        string
+    Tags            []string
+    Pinned          bool
+    // TreatEmptyAsFailure overrides the service default when not nil.
+    TreatEmptyAsFailure *bool
+    // Validations override the service policies by class, e.g.
+    // "content-type": "warn".
+    Validations map[string]string
+    // Failures requests the failure history of the feed with its info.
+    Failures bool
+}
+
+func (o RegisterOptions) values(v url.Values) {
+    if o.Element != "" {
+        v.Set("element", o.Element)
+    }
+    if o.Parent != "" {
+        v.Set("parent", o.Parent)
+    }
+    if o.Attr != "" {
+        v.Set("attr", o.Attr)
+    }
+    if o.Root != "" {
+        v.Set("root", o.Root)
+    }
+    if o.MaxSize > 0 {
+        v.Set("maxSize", strconv.FormatInt(o.MaxSize, 10))
+    }
+    if o.AbortOverBudget {
+        v.Set("abortOverBudget", "true")
+    }
+    if o.Verify {
+        v.Set("verify", "true")
+    }
+    if o.TolerateCorruptTrailer {
+        v.Set("tolerateCorruptTrailer", "true")
+    }
+    if o.SampleBytes > 0 {
+        v.Set("sampleBytes", strconv.FormatInt(o.SampleBytes, 10))
+    }
+    if o.SizeFallback {
+        v.Set("sizeFallback", "true")
+    }
+    if o.LivenessOnly {
+        v.Set("livenessOnly", "true")
+    }
+    if o.ProbeArchive {
+        v.Set("probeArchive", "true")
+    }
+    if o.Index {
+        v.Set("index", "true")
+    }
+    if o.Paged {
+        v.Set("paged", "true")
+    }
+    if o.Timeout > 0 {
+        v.Set("timeout", o.Timeout.String())
+    }
+    for name, value := range o.Headers {
+        v.Add("header", name+": "+value)
+    }
+    if o.Cookies {
+        v.Set("cookies", "true")
+    }
+    if o.Proxy != "" {
+        v.Set("proxy", o.Proxy)
+    }
+    if o.Language != "" {
+        v.Set("lang", o.Language)
+    }
+    if o.Pinned {
+        v.Set("pinned", "true")
+    }
+    if o.TreatEmptyAsFailure != nil {
+        v.Set("treatEmptyAsFailure", strconv.FormatBool(*o.TreatEmptyAsFailure))
+    }
+    if o.Failures {
+        v.Set("failures", "true")
+    }
+    if len(o.Validations) > 0 {
+        policies := make([]string, 0, len(o.Validations))
+        for class, policy := range o.Validations {
+            policies = append(policies, class+"="+policy)
+        }
+        sort.Strings(policies)
+        v.Set("validations", strings.Join(policies, ","))
+    }
+    for _, tag := range o.Tags {
+        v.Add("tag", tag)
+    }
+}
+
+// FeedInfo returns the info about feedURL, starting its monitoring if needed.
+// Until the first count completes the info has "counting" status.
+func (c *Client) FeedInfo(ctx context.Context, feedURL string) (*FeedInfo, error) {
+    return c.Register(ctx, feedURL, RegisterOptions{})
+}
+
+// Register starts monitoring of feedURL with opts and returns its info.
+func (c *Client) Register(ctx context.Context, feedURL string, opts RegisterOptions) (*FeedInfo, error) {
+    v := url.Values{"url": {feedURL}}
+    opts.values(v)
+    res, err := c.do(ctx, http.MethodGet, "/feedinfo", v)
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+    switch res.StatusCode {
+    case http.StatusOK, http.StatusAccepted, http.StatusExpectationFailed:
+        var fi FeedInfo
+        if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+            return nil, fmt.Errorf("Error decoding feed info: %v", err)
+        }
+        if res.StatusCode == http.StatusExpectationFailed {
+            return nil, &FailedError{Info: &fi}
+        }
+        return &fi, nil
+    case http.StatusNotFound:
+        return nil, ErrFeedNotAlive
+    case http.StatusPaymentRequired:
+        body, _ := ioutil.ReadAll(res.Body)
+        // the first line is the message, the monitored feeds follow
+        lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+        return nil, &LimitError{Monitored: lines[1:]}
+    default:
+        return nil, statusError(res)
+    }
+}
+
+// Feeds lists the monitored feeds having any of tags, all feeds if no tags
+// are given.
+func (c *Client) Feeds(ctx context.Context, tags ...string) ([]Feed, error) {
+    res, err := c.do(ctx, http.MethodGet, "/feeds", url.Values{"tag": tags})
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK {
+        return nil, statusError(res)
+    }
+    var feeds []Feed
+    if err := json.NewDecoder(res.Body).Decode(&feeds); err != nil {
+        return nil, fmt.Errorf("Error decoding feeds: %v", err)
+    }
+    return feeds, nil
+}
+
+// FeedsPage lists up to limit monitored feeds having any of tags starting at
+// cursor, "" for the first page. It returns the cursor of the next page, ""
+// after the last one, and the number of feeds in all pages.
+func (c *Client) FeedsPage(ctx context.Context, limit int, cursor string, tags ...string) (feeds []Feed, next string, total int, err error) {
+    q := url.Values{"tag": tags, "limit": {strconv.Itoa(limit)}}
+    if cursor != "" {
+        q.Set("cursor", cursor)
+    }
+    res, err := c.do(ctx, http.MethodGet, "/feeds", q)
+    if err != nil {
+        return nil, "", 0, err
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK {
+        return nil, "", 0, statusError(res)
+    }
+    if err := json.NewDecoder(res.Body).Decode(&feeds); err != nil {
+        return nil, "", 0, fmt.Errorf("Error decoding feeds: %v", err)
+    }
+    total, _ = strconv.Atoi(res.Header.Get("X-Total-Count"))
+    return feeds, res.Header.Get("X-Next-Cursor"), total, nil
+}
+
+// Remove stops monitoring of feeds with urls starting with prefix and returns
+// the number of removed feeds. The server refuses it without -api-key.
+func (c *Client) Remove(ctx context.Context, prefix string) (int, error) {
+    res, err := c.do(ctx, http.MethodDelete, "/feeds", url.Values{"prefix": {prefix}})
+    if err != nil {
+        return 0, err
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK {
+        return 0, statusError(res)
+    }
+    var removed struct {
+        Removed int `json:"removed"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&removed); err != nil {
+        return 0, fmt.Errorf("Error decoding response: %v", err)
+    }
+    return removed.Removed, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+    u := c.BaseURL + path
+    if len(query) > 0 {
+        u += "?" + query.Encode()
+    }
+    req, err := http.NewRequestWithContext(ctx, method, u, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/json")
+    if c.APIKey != "" {
+        req.Header.Set("X-API-Key", c.APIKey)
+    }
+    hc := c.HTTPClient
+    if hc == nil {
+        hc = http.DefaultClient
+    }
+    return hc.Do(req)
+}
+
+func statusError(res *http.Response) error {
+    body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+    return &StatusError{Code: res.StatusCode, Body: strings.TrimSpace(string(body))}
+}
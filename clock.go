@@ -0,0 +1,133 @@
+package main
+
+import (
+    "hash/fnv"
+    "sync"
+    "time"
+)
+
+// Clock is the time source of feed monitoring: idle and failure windows,
+// recount intervals and poll ticks. Tests replace it with a fakeClock to move
+// through hours of monitoring instantly.
+type Clock interface {
+    Now() time.Time
+    NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the monitor uses.
+type Ticker interface {
+    C() <-chan time.Time
+    Reset(d time.Duration)
+    Stop()
+}
+
+var clock Clock = realClock{}
+
+// since is time.Since by clock.
+func since(t time.Time) time.Duration {
+    return clock.Now().Sub(t)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+    return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+    *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// fakeClock is a manually advanced clock, its tickers fire on Advance.
+type fakeClock struct {
+    mu      sync.Mutex
+    now     time.Time
+    tickers []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+    return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+    c.tickers = append(c.tickers, t)
+    return t
+}
+
+// Advance moves the clock by d firing the tickers due. Like time.Ticker a
+// ticker drops ticks its reader is late for.
+func (c *fakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+    for _, t := range c.tickers {
+        if t.stopped || t.next.After(c.now) {
+            continue
+        }
+        select {
+        case t.c <- c.now:
+        default:
+        }
+        for !t.next.After(c.now) {
+            t.next = t.next.Add(t.period)
+        }
+    }
+}
+
+type fakeTicker struct {
+    clock   *fakeClock
+    c       chan time.Time
+    period  time.Duration
+    next    time.Time
+    stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+    t.clock.mu.Lock()
+    defer t.clock.mu.Unlock()
+    t.period, t.next, t.stopped = d, t.clock.now.Add(d), false
+}
+
+func (t *fakeTicker) Stop() {
+    t.clock.mu.Lock()
+    defer t.clock.mu.Unlock()
+    t.stopped = true
+}
+
+// jitterFraction is a fraction in [0, 1) fixed by url, it spreads timeouts
+// of feeds reproducibly across restarts and replicas.
+func jitterFraction(url string) float64 {
+    h := fnv.New64a()
+    h.Write([]byte(url))
+    // FNV of urls differing in the last bytes differs in the low bits
+    // mostly, mix them in
+    x := h.Sum64()
+    x ^= x >> 33
+    x *= 0xff51afd7ed558ccd
+    x ^= x >> 33
+    return float64(x>>11) / (1 << 53)
+}
+
+// timeoutJitter is the fraction of -timeout-jitter.
+func timeoutJitter(fraction float64) time.Duration {
+    return time.Duration(fraction * float64(cfg().TimeoutJitter.Duration))
+}
+
+func (fi FeedInfo) jitter() time.Duration {
+    return timeoutJitter(fi.Jitter)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// useFakeClock makes the monitoring of the test run by a fake clock. It's
+// called before forgetFeedsAfter, so the clock is restored after the poll
+// loops stop.
+func useFakeClock(t *testing.T) *fakeClock {
+    prev := clock
+    c := newFakeClock(time.Now())
+    clock = c
+    t.Cleanup(func() { clock = prev })
+    return c
+}
+
+// registerFeedForTest registers url with a /feedinfo request and waits for
+// its first count.
+func registerFeedForTest(t *testing.T, url string) {
+    t.Helper()
+    w := httptest.NewRecorder()
+    feedInfoHandler(w, httptest.NewRequest(http.MethodGet, "/feedinfo?url="+url, nil))
+    if w.Code != http.StatusAccepted {
+        t.Fatalf("registration answered %d: %s", w.Code, w.Body)
+    }
+    waitCounted(t, url)
+}
+
+func TestIdleFeedIsForgotten(t *testing.T) {
+    c := useFakeClock(t)
+    forgetFeedsAfter(t)
+    srv := httptest.NewServer(feedHandler(gzipped(t, vacanciesXML(2))))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    registerFeedForTest(t, url)
+
+    c.Advance(cfg().IdleTimeout.Duration / 2)
+    time.Sleep(50 * time.Millisecond)
+    mu.RLock()
+    _, monitored := updaters[url]
+    mu.RUnlock()
+    if !monitored {
+        t.Fatal("feed is forgotten before the idle timeout")
+    }
+
+    c.Advance(cfg().IdleTimeout.Duration/2 + time.Minute)
+    waitFor(t, "the idle feed to be forgotten", func() bool {
+        mu.RLock()
+        defer mu.RUnlock()
+        _, monitored := updaters[url]
+        _, counted := info[url]
+        return !monitored && !counted
+    })
+}
+
+func TestPausePastIdleTimeoutKeepsFeeds(t *testing.T) {
+    c := useFakeClock(t)
+    forgetFeedsAfter(t)
+    t.Cleanup(func() { paused.Store(false) })
+    srv := httptest.NewServer(feedHandler(gzipped(t, vacanciesXML(2))))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    registerFeedForTest(t, url)
+    setPaused := func(pause bool) {
+        w := httptest.NewRecorder()
+        pauseHandler(pause)(w, httptest.NewRequest(http.MethodPost, "/admin/pause", nil))
+        if w.Code != http.StatusOK {
+            t.Fatalf("pause %v answered %d", pause, w.Code)
+        }
+    }
+
+    setPaused(true)
+    c.Advance(2 * cfg().IdleTimeout.Duration)
+    setPaused(false)
+    c.Advance(cfg().PollInterval.Duration)
+    time.Sleep(50 * time.Millisecond)
+    mu.RLock()
+    _, monitored := updaters[url]
+    mu.RUnlock()
+    if !monitored {
+        t.Fatal("feed is forgotten right after a pause longer than the idle timeout")
+    }
+
+    // idleness accrues again after the resume
+    c.Advance(cfg().IdleTimeout.Duration + time.Minute)
+    waitFor(t, "the idle feed to be forgotten", func() bool {
+        mu.RLock()
+        defer mu.RUnlock()
+        _, monitored := updaters[url]
+        return !monitored
+    })
+}
+
+func TestFailedFeedAnswers417(t *testing.T) {
+    c := useFakeClock(t)
+    forgetFeedsAfter(t)
+    var failing atomic.Bool
+    feed := feedHandler(gzipped(t, vacanciesXML(2)))
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if failing.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        feed(w, r)
+    }))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    registerFeedForTest(t, url)
+
+    failing.Store(true)
+    c.Advance(cfg().PollInterval.Duration)
+    waitFor(t, "the failed check", func() bool {
+        mu.RLock()
+        defer mu.RUnlock()
+        return !info[url].FailureSince.IsZero()
+    })
+    get := func() int {
+        w := httptest.NewRecorder()
+        feedInfoHandler(w, httptest.NewRequest(http.MethodGet, "/feedinfo?url="+url, nil))
+        return w.Code
+    }
+    if code := get(); code != http.StatusOK {
+        t.Errorf("failing feed answered %d, expected its last info with 200", code)
+    }
+
+    c.Advance(cfg().FailureTimeout.Duration + time.Minute)
+    if code := get(); code != http.StatusExpectationFailed {
+        t.Errorf("feed failing past the failure timeout answered %d, expected 417", code)
+    }
+    mu.RLock()
+    _, monitored := updaters[url]
+    mu.RUnlock()
+    if !monitored {
+        t.Error("failed feed isn't monitored any more")
+    }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+)
+
+// compareSide is one of the compared feeds.
+type compareSide struct {
+    URL            string `json:"url"`
+    Status         string `json:"status"`
+    VacanciesCount *int64 `json:"vacanciesCount"`
+    SizeBytes      int64  `json:"sizeBytes,omitempty"`
+}
+
+type compareResponse struct {
+    A compareSide `json:"a"`
+    B compareSide `json:"b"`
+    // the deltas are b - a, nil until both feeds are counted
+    CountDelta *int64 `json:"countDelta"`
+    SizeDelta  *int64 `json:"sizeDelta"`
+    Agree      *bool  `json:"agree"`
+}
+
+// compareHandler serves GET /compare?a=URL1&b=URL2 with the counts of two
+// feeds, e.g. a primary and its mirror, and their difference. Feeds which
+// aren't monitored are registered with the default options.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+    var resp compareResponse
+    code := http.StatusOK
+    for _, side := range []struct {
+        param string
+        into  *compareSide
+    }{{"a", &resp.A}, {"b", &resp.B}} {
+        raw := r.URL.Query().Get(side.param)
+        if raw == "" {
+            writeError(w, r, http.StatusBadRequest, apiError{
+                Code: "missing_" + side.param, Message: side.param + " parameter is required", Required: []string{"a", "b"}})
+            return
+        }
+        url, err := canonicalURL(raw)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, apiError{Code: "invalid_" + side.param, Message: err.Error()})
+            return
+        }
+        mu.RLock()
+        _, monitored := updaters[url]
+        mu.RUnlock()
+        if monitored && !authorizedRead(r) || !monitored && !authorized(r) {
+            unauthorized(w)
+            return
+        }
+        *side.into = compareSide{URL: url}
+        if !monitored {
+            failure, failureCode := "", 0
+            if !cfg().hostAllowed(url) {
+                failure, failureCode = "forbidden", http.StatusForbidden
+            } else {
+                switch err := registerFeed(traceContextFromRequest(r), url, FeedOptions{}); err {
+                case nil:
+                case errFeedNotAlive:
+                    failure, failureCode = "not-alive", http.StatusNotFound
+                case errFeedsLimit:
+                    failure, failureCode = "limit", http.StatusPaymentRequired
+                default:
+                    failure, failureCode = "error", http.StatusInternalServerError
+                }
+            }
+            if failure != "" {
+                side.into.Status = failure
+                if code < failureCode {
+                    code = failureCode
+                }
+                continue
+            }
+        }
+        feed, counted, _ := touchFeed(url)
+        if !counted {
+            side.into.Status = "counting"
+            if code == http.StatusOK {
+                code = http.StatusAccepted
+            }
+            continue
+        }
+        count := feed.VacanciesCount
+        side.into.Status = feed.status()
+        side.into.VacanciesCount = &count
+        side.into.SizeBytes = feed.SizeBytes
+    }
+    if resp.A.VacanciesCount != nil && resp.B.VacanciesCount != nil {
+        countDelta := *resp.B.VacanciesCount - *resp.A.VacanciesCount
+        sizeDelta := resp.B.SizeBytes - resp.A.SizeBytes
+        agree := countDelta == 0
+        resp.CountDelta, resp.SizeDelta, resp.Agree = &countDelta, &sizeDelta, &agree
+    }
+
+    if wantsJSON(r) {
+        writeJSON(w, code, resp)
+        return
+    }
+    w.WriteHeader(code)
+    for _, side := range []compareSide{resp.A, resp.B} {
+        if side.VacanciesCount != nil {
+            fmt.Fprintf(w, "%s: %d vacancies, %d bytes\n", side.URL, *side.VacanciesCount, side.SizeBytes)
+        } else {
+            fmt.Fprintf(w, "%s: %s\n", side.URL, side.Status)
+        }
+    }
+    if resp.CountDelta != nil {
+        fmt.Fprintf(w, "delta: %d vacancies, %d bytes\n", *resp.CountDelta, *resp.SizeDelta)
+    }
+}
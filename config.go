@@ -0,0 +1,338 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "mime"
+    "net/url"
+    "os"
+    "os/signal"
+    "reflect"
+    "strings"
+    "sync/atomic"
+    "syscall"
+    "time"
+)
+
+// Duration is a time.Duration written as "1m30s" in the config file.
+type Duration struct {
+    time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+    var s string
+    if err := json.Unmarshal(b, &s); err != nil {
+        return fmt.Errorf("duration should be a string like \"1m30s\": %v", err)
+    }
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return err
+    }
+    d.Duration = v
+    return nil
+}
+
+// Config holds the settings which can be changed without a restart: they are
+// initialized from flags, overridden by the -config file and re-read from it
+// on SIGHUP. Each check uses the config current at its start.
+type Config struct {
+    PollInterval       Duration `json:"pollInterval"`
+    MinRecountInterval Duration `json:"minRecountInterval"`
+    // StatInterval is the minimum time between stat fetches of a healthy
+    // feed, 0 - every poll.
+    StatInterval Duration `json:"statInterval"`
+    // RequestTimeout limits every request to a feed, 0 - no limit.
+    RequestTimeout Duration `json:"requestTimeout"`
+    // MaxFeedTimeout limits the timeouts feeds may be registered with, 0 -
+    // no limit.
+    MaxFeedTimeout Duration `json:"maxFeedTimeout"`
+    IdleTimeout    Duration `json:"idleTimeout"`
+    FailureTimeout Duration `json:"failureTimeout"`
+    // TimeoutJitter extends IdleTimeout and FailureTimeout of each feed by
+    // up to this much, so that feeds registered together don't expire at
+    // once.
+    TimeoutJitter Duration `json:"timeoutJitter"`
+    // DeadFeedTimeout is how long a feed answering 404 or 410 is monitored,
+    // 0 - till FailureTimeout and IdleTimeout as other failures.
+    DeadFeedTimeout Duration `json:"deadFeedTimeout"`
+    // ArchiveUnavailableTimeout is how long a feed which stat answers but
+    // archive is 404 or 410 is monitored, 0 - as other failures.
+    ArchiveUnavailableTimeout Duration `json:"archiveUnavailableTimeout"`
+    // FrozenAfter is how long feed content may stay the same before the
+    // feed is reported frozen, 0 - never.
+    FrozenAfter Duration `json:"frozenAfter"`
+    // BreakerThreshold consecutive failures suspend checks of a feed for
+    // BreakerCooldown, 0 - never.
+    BreakerThreshold int      `json:"breakerThreshold"`
+    BreakerCooldown  Duration `json:"breakerCooldown"`
+    // MaxXMLDepth is the deepest element nesting accepted in a feed.
+    MaxXMLDepth int `json:"maxXMLDepth"`
+    // VacancyElement is the name of the counted element.
+    VacancyElement string `json:"vacancyElement"`
+    // VacancyParent is the element counted ones must be direct children of,
+    // empty - counted anywhere.
+    VacancyParent string `json:"vacancyParent"`
+    // VacancyAttr is the attribute rule counted ones must pass, e.g.
+    // status!=archived, empty - all are counted.
+    VacancyAttr string `json:"vacancyAttr"`
+    // RootElement is the expected root element of feeds, empty - any.
+    RootElement string `json:"rootElement"`
+    // ReadyMaxFailing is the fraction of failing feeds which makes /readyz
+    // fail, 0 - disabled.
+    ReadyMaxFailing float64 `json:"readyMaxFailing"`
+    // PendingStatusOK answers 200 with "pending" status instead of 202 for
+    // feeds not counted yet.
+    PendingStatusOK bool `json:"pendingStatusOK"`
+    // ServeLastGood answers the last good info of a failed feed with 200 and
+    // the failure duration instead of 417.
+    ServeLastGood bool `json:"serveLastGood"`
+    // AllowedHosts limits hosts feeds may be registered from, entries
+    // starting with a dot allow subdomains. Empty - any host.
+    AllowedHosts []string `json:"allowedHosts"`
+    // RedirectHostPolicy is what to do when the stat and the archive of a
+    // feed are served by different hosts after redirects: "warn" flags the
+    // feed, "fail" fails its count, "off" doesn't check.
+    RedirectHostPolicy string `json:"redirectHostPolicy"`
+    // TreatEmptyAsFailure fails checks of feeds counted with no vacancies.
+    TreatEmptyAsFailure bool `json:"treatEmptyAsFailure"`
+    // Validations are the off, warn or fail policies of validation classes,
+    // they override RedirectHostPolicy and TreatEmptyAsFailure.
+    Validations map[string]checkPolicy `json:"validations"`
+    // ArchiveContentTypes and StatContentTypes are the media types accepted
+    // in responses, "type/*" matches any subtype. Empty - any, a response
+    // without Content-Type is always accepted.
+    ArchiveContentTypes []string `json:"archiveContentTypes"`
+    StatContentTypes    []string `json:"statContentTypes"`
+}
+
+var defaultArchiveContentTypes = []string{
+    "application/gzip", "application/x-gzip", "application/xml", "text/xml", "application/octet-stream",
+}
+
+var configPath = flag.String("config", "", "JSON file with reloadable settings, re-read on SIGHUP")
+
+// flagConfig is filled from the command line, the config file is applied
+// on top of it.
+var flagConfig Config
+
+func init() {
+    flag.DurationVar(&flagConfig.PollInterval.Duration, "poll-interval", time.Minute, "how often feed stat is checked")
+    flag.DurationVar(&flagConfig.MinRecountInterval.Duration, "min-recount-interval", 0, "minimum time between vacancies recounts of a feed")
+    flag.DurationVar(&flagConfig.StatInterval.Duration, "stat-interval", 0, "minimum time between stat fetches of a feed which last check succeeded, 0 - every -poll-interval")
+    flag.DurationVar(&flagConfig.RequestTimeout.Duration, "request-timeout", 0, "timeout of requests to feeds, 0 - none")
+    flag.DurationVar(&flagConfig.MaxFeedTimeout.Duration, "max-feed-timeout", 30*time.Minute, "maximum request timeout a feed may be registered with, 0 - any")
+    flag.DurationVar(&flagConfig.IdleTimeout.Duration, "idle-timeout", 6*time.Hour, "how long a feed is monitored without being requested")
+    flag.DurationVar(&flagConfig.FailureTimeout.Duration, "failure-timeout", 6*time.Hour, "how long a feed may fail before its info is reported unavailable")
+    flag.DurationVar(&flagConfig.ArchiveUnavailableTimeout.Duration, "archive-unavailable-timeout", 30*time.Minute, "how long a feed which stat answers but archive is 404 or 410 is monitored before eviction, 0 - no early eviction")
+    flag.DurationVar(&flagConfig.TimeoutJitter.Duration, "timeout-jitter", 0, "extend idle and failure timeouts of each feed by up to this much, fixed per feed url")
+    flag.DurationVar(&flagConfig.DeadFeedTimeout.Duration, "dead-feed-timeout", 10*time.Minute, "how long a feed answering 404 or 410 is monitored before eviction, 0 - no early eviction")
+    flag.DurationVar(&flagConfig.FrozenAfter.Duration, "frozen-after", 0, "report a feed frozen when its content doesn't change for this long, 0 - never")
+    flag.IntVar(&flagConfig.BreakerThreshold, "breaker-threshold", 10, "consecutive failures after which checks of a feed are suspended, 0 - never")
+    flag.DurationVar(&flagConfig.BreakerCooldown.Duration, "breaker-cooldown", 15*time.Minute, "how long checks of a feed are suspended by its circuit breaker")
+    flag.IntVar(&flagConfig.MaxXMLDepth, "max-xml-depth", 256, "maximum element nesting accepted in a feed")
+    flag.StringVar(&flagConfig.VacancyElement, "vacancy-element", "vacancy", "name of the counted element, {namespace}name to match the namespace too")
+    flag.StringVar(&flagConfig.VacancyParent, "vacancy-parent", "", "count vacancy elements only directly inside this one, e.g. vacancies; empty - anywhere")
+    flag.StringVar(&flagConfig.VacancyAttr, "vacancy-attr", "", "count vacancy elements only passing this attribute rule: name, !name, name=value or name!=value; empty - all")
+    flag.StringVar(&flagConfig.RootElement, "root-element", "", "expected root element of feeds, e.g. vacancies; other documents fail the count")
+    flag.Float64Var(&flagConfig.ReadyMaxFailing, "ready-max-failing", 0,
+        "fraction (0..1] of failing feeds at which /readyz reports not ready, 0 - ready while serving")
+    flag.BoolVar(&flagConfig.PendingStatusOK, "pending-200", false, "answer 200 with \"pending\" status instead of 202 until the first count of a feed")
+    flag.BoolVar(&flagConfig.ServeLastGood, "serve-last-good", false, "answer the last good info of a failed feed, marked failed, instead of 417")
+    flag.Func("allowed-hosts", "comma separated hosts feeds may be registered from, .example.com allows subdomains", func(s string) error {
+        flagConfig.AllowedHosts = strings.Split(s, ",")
+        return nil
+    })
+    flag.StringVar(&flagConfig.RedirectHostPolicy, "redirect-host-policy", "warn",
+        "off|warn|fail, what to do when a feed stat and archive are served by different hosts after redirects")
+    flag.BoolVar(&flagConfig.TreatEmptyAsFailure, "treat-empty-as-failure", false, "fail checks of feeds with no vacancies")
+    flag.Func("validations", "comma separated class=off|warn|fail policies of "+strings.Join(validationClasses, ", ")+" validations", func(s string) (err error) {
+        flagConfig.Validations, err = parsePolicies(s)
+        return err
+    })
+    flagConfig.ArchiveContentTypes = defaultArchiveContentTypes
+    flag.Func("archive-content-types", "comma separated Content-Types accepted for archives, empty - any (default "+
+        strings.Join(defaultArchiveContentTypes, ",")+")", func(s string) error {
+        flagConfig.ArchiveContentTypes = splitList(s)
+        return nil
+    })
+    flag.Func("stat-content-types", "comma separated Content-Types accepted for stats, empty - any", func(s string) error {
+        flagConfig.StatContentTypes = splitList(s)
+        return nil
+    })
+}
+
+var config atomic.Pointer[Config]
+
+// cfg returns the current config, the returned value must not be modified.
+// Until the config is loaded flag defaults apply.
+func cfg() *Config {
+    if c := config.Load(); c != nil {
+        return c
+    }
+    return &flagConfig
+}
+
+func loadConfig() (*Config, error) {
+    c := flagConfig
+    c.AllowedHosts = append([]string(nil), flagConfig.AllowedHosts...)
+    c.ArchiveContentTypes = append([]string(nil), flagConfig.ArchiveContentTypes...)
+    c.StatContentTypes = append([]string(nil), flagConfig.StatContentTypes...)
+    // the file adds to the policies of the flag, not to the flag's map
+    c.Validations = make(map[string]checkPolicy, len(flagConfig.Validations))
+    for class, policy := range flagConfig.Validations {
+        c.Validations[class] = policy
+    }
+    if *configPath != "" {
+        b, err := ioutil.ReadFile(*configPath)
+        if err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(b, &c); err != nil {
+            return nil, fmt.Errorf("Error parsing %s: %v", *configPath, err)
+        }
+    }
+    if err := c.validate(); err != nil {
+        return nil, err
+    }
+    return &c, nil
+}
+
+func (c *Config) validate() error {
+    if c.PollInterval.Duration <= 0 {
+        return fmt.Errorf("pollInterval should be positive")
+    }
+    if c.MaxFeedTimeout.Duration < 0 {
+        return fmt.Errorf("maxFeedTimeout should not be negative")
+    }
+    if c.StatInterval.Duration < 0 {
+        return fmt.Errorf("statInterval should not be negative")
+    }
+    if c.TimeoutJitter.Duration < 0 {
+        return fmt.Errorf("timeoutJitter should not be negative")
+    }
+    if c.MaxXMLDepth <= 0 {
+        return fmt.Errorf("maxXMLDepth should be positive")
+    }
+    if _, err := parseElementName(c.VacancyElement); err != nil {
+        return fmt.Errorf("vacancyElement: %v", err)
+    }
+    if c.RootElement != "" {
+        if _, err := parseElementName(c.RootElement); err != nil {
+            return fmt.Errorf("rootElement: %v", err)
+        }
+    }
+    if c.VacancyParent != "" {
+        if _, err := parseElementName(c.VacancyParent); err != nil {
+            return fmt.Errorf("vacancyParent: %v", err)
+        }
+    }
+    if c.VacancyAttr != "" {
+        if _, err := parseAttrRule(c.VacancyAttr); err != nil {
+            return fmt.Errorf("vacancyAttr: %v", err)
+        }
+    }
+    if c.ReadyMaxFailing < 0 || c.ReadyMaxFailing > 1 {
+        return fmt.Errorf("readyMaxFailing should be within 0..1")
+    }
+    switch c.RedirectHostPolicy {
+    case "off", "warn", "fail":
+    default:
+        return fmt.Errorf("redirectHostPolicy should be off, warn or fail")
+    }
+    if err := validatePolicies(c.Validations); err != nil {
+        return fmt.Errorf("validations: %v", err)
+    }
+    for i, h := range c.AllowedHosts {
+        c.AllowedHosts[i] = strings.ToLower(strings.TrimSpace(h))
+    }
+    for _, types := range [][]string{c.ArchiveContentTypes, c.StatContentTypes} {
+        for i, t := range types {
+            types[i] = strings.ToLower(strings.TrimSpace(t))
+            if !strings.Contains(types[i], "/") {
+                return fmt.Errorf("Invalid content type %q: expected type/subtype", t)
+            }
+        }
+    }
+    return nil
+}
+
+// splitList splits a comma separated flag value, empty is an empty list.
+func splitList(s string) []string {
+    if strings.TrimSpace(s) == "" {
+        return nil
+    }
+    return strings.Split(s, ",")
+}
+
+// contentTypeAllowed checks a Content-Type header against allowed media
+// types.
+func contentTypeAllowed(contentType string, allowed []string) bool {
+    if len(allowed) == 0 || contentType == "" {
+        return true
+    }
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return false
+    }
+    for _, a := range allowed {
+        if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*"))) {
+            return true
+        }
+    }
+    return false
+}
+
+// hostAllowed checks the host of a canonical feed url against AllowedHosts.
+func (c *Config) hostAllowed(feedURL string) bool {
+    if len(c.AllowedHosts) == 0 {
+        return true
+    }
+    u, err := url.Parse(feedURL)
+    if err != nil {
+        return false
+    }
+    host := u.Hostname()
+    for _, allowed := range c.AllowedHosts {
+        if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+            return true
+        }
+    }
+    return false
+}
+
+func reloadConfig() {
+    c, err := loadConfig()
+    if err != nil {
+        log.Printf("Error reloading config, keep the current one: %v\n", err)
+        return
+    }
+    old := config.Swap(c)
+    changed := false
+    ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*c)
+    for i := 0; i < nv.NumField(); i++ {
+        if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+            log.Printf("config: %s changed from %v to %v\n", nv.Type().Field(i).Name, ov.Field(i), nv.Field(i))
+            changed = true
+        }
+    }
+    if !changed {
+        log.Println("config reloaded, nothing changed")
+    }
+}
+
+// reloadConfigOnSIGHUP re-reads the config on every SIGHUP. Listen address
+// and other flags outside of Config need a restart.
+func reloadConfigOnSIGHUP() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    for range hup {
+        reloadConfig()
+    }
+}
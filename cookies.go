@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/cookiejar"
+)
+
+type cookieJarKey struct{}
+
+// withCookieJar gives the requests of a check of a feed keeping cookies a
+// jar of their own, so a session cookie set by the stat is sent for the
+// archive. The jar lasts for the check, it's never shared with other feeds
+// or checks.
+func withCookieJar(ctx context.Context, opts FeedOptions) context.Context {
+    if !opts.Cookies {
+        return ctx
+    }
+    // New fails only with options
+    jar, _ := cookiejar.New(nil)
+    return context.WithValue(ctx, cookieJarKey{}, jar)
+}
+
+// doFeedRequest sends a request to a feed with the cookie jar of its check,
+// if it has one.
+func doFeedRequest(req *http.Request) (*http.Response, error) {
+    if jar, ok := req.Context().Value(cookieJarKey{}).(http.CookieJar); ok {
+        return (&http.Client{Transport: feedTransport, Jar: jar}).Do(req)
+    }
+    return feedClient.Do(req)
+}
+
+// redactedCookies returns a copy of header with the cookies set removed.
+func redactedCookies(header http.Header) http.Header {
+    if _, ok := header["Set-Cookie"]; !ok {
+        return header
+    }
+    header = header.Clone()
+    for i := range header["Set-Cookie"] {
+        header["Set-Cookie"][i] = "[redacted]"
+    }
+    return header
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+)
+
+// countHandler serves /count?url=URL with just the vacancies count of the
+// feed: 202 until it's counted, 417 when it's failed unless the last good
+// count is served, registration and the other answers are as of /feedinfo.
+func countHandler(w http.ResponseWriter, r *http.Request) {
+    url, feed, counted, _, ok := requestedFeed(w, r)
+    if !ok {
+        return
+    }
+    switch {
+    case !counted:
+        code := http.StatusAccepted
+        if cfg().PendingStatusOK {
+            code = http.StatusOK
+        }
+        setQueueDepth(w)
+        if wantsJSON(r) {
+            writeJSON(w, code, map[string]interface{}{"count": nil})
+            return
+        }
+        w.WriteHeader(code)
+        w.Write([]byte("counting vacancies\n"))
+    case feed.status() == "failed" && cfg().ServeLastGood:
+        msg := lastGoodMessage(url, feed)
+        if wantsJSON(r) {
+            writeJSON(w, http.StatusOK, map[string]interface{}{
+                "count": feed.VacanciesCount, "failed": true, "failingForSeconds": int64(since(feed.FailureSince).Seconds()), "error": msg,
+            })
+            return
+        }
+        w.Write([]byte(fmt.Sprintf("%d (failed: %s)\n", feed.VacanciesCount, msg)))
+    case feed.status() == "failed":
+        msg := failedMessage(url)
+        if wantsJSON(r) {
+            writeJSON(w, http.StatusExpectationFailed, map[string]interface{}{"count": nil, "error": msg})
+            return
+        }
+        w.WriteHeader(http.StatusExpectationFailed)
+        w.Write([]byte(msg))
+    case feed.LivenessOnly:
+        if wantsJSON(r) {
+            writeJSON(w, http.StatusOK, map[string]interface{}{"count": nil, "livenessOnly": true})
+            return
+        }
+        w.Write([]byte("not counted, liveness only\n"))
+    case wantsJSON(r):
+        resp := map[string]interface{}{"count": feed.VacanciesCount}
+        if feed.Estimated {
+            resp["estimated"] = true
+        }
+        writeJSON(w, http.StatusOK, resp)
+    case feed.Estimated:
+        w.Write([]byte(fmt.Sprintf("%d (estimated)\n", feed.VacanciesCount)))
+    default:
+        w.Write([]byte(fmt.Sprintf("%d\n", feed.VacanciesCount)))
+    }
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "log"
+    "net/http"
+    "time"
+)
+
+// A tight -write-timeout protects the server from clients reading slowly,
+// but a large listing written to a legitimately slow client can't fit in
+// it. Such responses get their own -stream-write-timeout instead of one
+// timeout too long for the rest or too short for them.
+var (
+    writeTimeout       = flag.Duration("write-timeout", 0, "time to write a response, 0 - unlimited")
+    streamWriteTimeout = flag.Duration("stream-write-timeout", 10*time.Minute, "time to write a /feeds or /export response when -write-timeout is set, 0 - unlimited")
+)
+
+// withStreamDeadline gives the responses of h -stream-write-timeout from
+// the start of the handler instead of -write-timeout.
+func withStreamDeadline(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if *writeTimeout > 0 {
+            var deadline time.Time
+            if *streamWriteTimeout > 0 {
+                deadline = time.Now().Add(*streamWriteTimeout)
+            }
+            if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+                log.Printf("Error extending write deadline of %s: %v", r.URL.Path, err)
+            }
+        }
+        h(w, r)
+    }
+}
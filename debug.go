@@ -0,0 +1,113 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+)
+
+var debugAnyURL = flag.Bool("debug-any-url", false, "allow /debug/feeds/ to fetch feeds which aren't monitored")
+
+const (
+    // debugTimeout limits debug fetches when -request-timeout doesn't.
+    debugTimeout = 30 * time.Second
+    maxDebugBody = 1 << 20
+)
+
+// rawStatResponse is the stat of a feed as fetched by the monitor.
+type rawStatResponse struct {
+    URL     string              `json:"url"`
+    Status  string              `json:"status"`
+    Headers map[string][]string `json:"headers"`
+    Body    string              `json:"body"`
+}
+
+// debugFeedHandler serves GET /debug/feeds/{url}/raw, url being escaped,
+// with the stat body and headers of a monitored feed re-fetched with its
+// options, as a check does. The info about the feed isn't changed.
+func debugFeedHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", http.MethodGet)
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    path := strings.TrimPrefix(r.URL.EscapedPath(), "/debug/feeds/")
+    escaped, ok := strings.CutSuffix(path, "/raw")
+    if !ok {
+        w.WriteHeader(http.StatusNotFound)
+        return
+    }
+    raw, err := url.PathUnescape(escaped)
+    if err == nil {
+        raw, err = canonicalURL(raw)
+    }
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error()))
+        return
+    }
+    mu.RLock()
+    opts, monitored := options[raw]
+    mu.RUnlock()
+    if !monitored && (!*debugAnyURL || !cfg().hostAllowed(raw)) {
+        w.WriteHeader(http.StatusNotFound)
+        w.Write([]byte("feed is not monitored\n"))
+        return
+    }
+
+    ctx, cancel := withRequestTimeout(r.Context(), opts)
+    defer cancel()
+    if _, ok := ctx.Deadline(); !ok {
+        ctx, cancel = context.WithTimeout(ctx, debugTimeout)
+        defer cancel()
+    }
+    ctx = withCookieJar(ctx, opts)
+    statUrl := fmt.Sprintf("%s?stat", raw)
+    req, err := newFeedRequest(ctx, statUrl, opts)
+    if err == nil {
+        var res *http.Response
+        if res, err = doFeedRequest(req); err == nil {
+            defer res.Body.Close()
+            var body []byte
+            body, err = io.ReadAll(io.LimitReader(res.Body, maxDebugBody))
+            if err == nil {
+                headers := res.Header
+                // the session of a feed keeping cookies is a secret
+                if opts.Cookies {
+                    headers = redactedCookies(headers)
+                }
+                writeRawStat(w, r, rawStatResponse{URL: statUrl, Status: res.Status, Headers: headers, Body: string(body)})
+                return
+            }
+        }
+    }
+    log.Printf("Error fetching stat from %s for debug: %v", statUrl, err)
+    w.WriteHeader(http.StatusBadGateway)
+    w.Write([]byte(fmt.Sprintf("Error fetching stat from %s: %v\n", statUrl, err)))
+}
+
+func writeRawStat(w http.ResponseWriter, r *http.Request, stat rawStatResponse) {
+    if wantsJSON(r) {
+        writeJSON(w, http.StatusOK, stat)
+        return
+    }
+    names := make([]string, 0, len(stat.Headers))
+    for name := range stat.Headers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    fmt.Fprintf(w, "%s\n", stat.Status)
+    for _, name := range names {
+        for _, value := range stat.Headers[name] {
+            fmt.Fprintf(w, "%s: %s\n", name, value)
+        }
+    }
+    fmt.Fprintf(w, "\n%s", stat.Body)
+}
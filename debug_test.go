@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+func TestDebugFeedHandler(t *testing.T) {
+    // the stat needs the session cookie it sets, as a check sends it
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if _, err := r.Cookie("session"); err != nil {
+            http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+            http.Redirect(w, r, r.URL.String(), http.StatusFound)
+            return
+        }
+        w.Write([]byte("size:10 bytes\n"))
+    }))
+    defer srv.Close()
+    feedURL := srv.URL + "/feed.xml.gz"
+    monitorForTest(t, feedURL, FeedOptions{Cookies: true})
+    prevKey := *apiKey
+    t.Cleanup(func() { *apiKey = prevKey })
+    debug := func() *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodGet, "/debug/feeds/"+url.PathEscape(feedURL)+"/raw", nil)
+        r.Header.Set("X-API-Key", "secret")
+        w := httptest.NewRecorder()
+        requireAdminKey(debugFeedHandler)(w, r)
+        return w
+    }
+
+    *apiKey = ""
+    if w := debug(); w.Code != http.StatusForbidden {
+        t.Errorf("without a configured key answered %d, expected 403", w.Code)
+    }
+    *apiKey = "secret"
+    if w := debug(); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "200 OK\n") ||
+        !strings.HasSuffix(w.Body.String(), "\nsize:10 bytes\n") {
+        t.Errorf("answered %d: %s, expected the stat fetched with the session cookie", w.Code, w.Body)
+    }
+}
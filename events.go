@@ -0,0 +1,107 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// feedEvent is sent to /events subscribers when the state of a feed changes.
+type feedEvent struct {
+    Type string            `json:"type"`
+    URL  string            `json:"url"`
+    Feed *feedInfoResponse `json:"feed,omitempty"`
+}
+
+const (
+    // eventsBuffer is how many events a subscriber may lag behind before it
+    // is disconnected, so that a slow client never delays the pollers.
+    eventsBuffer    = 64
+    eventsHeartbeat = 30 * time.Second
+)
+
+var (
+    subscribersMu sync.Mutex
+    subscribers   = make(map[chan feedEvent]struct{})
+)
+
+func subscribe() chan feedEvent {
+    ch := make(chan feedEvent, eventsBuffer)
+    subscribersMu.Lock()
+    subscribers[ch] = struct{}{}
+    subscribersMu.Unlock()
+    return ch
+}
+
+func unsubscribe(ch chan feedEvent) {
+    subscribersMu.Lock()
+    defer subscribersMu.Unlock()
+    if _, ok := subscribers[ch]; ok {
+        delete(subscribers, ch)
+        close(ch)
+    }
+}
+
+// publish never blocks: a subscriber with a full buffer is dropped, its
+// stream ends and the client reconnects.
+func publish(e feedEvent) {
+    subscribersMu.Lock()
+    defer subscribersMu.Unlock()
+    for ch := range subscribers {
+        select {
+        case ch <- e:
+        default:
+            delete(subscribers, ch)
+            close(ch)
+        }
+    }
+}
+
+func publishFeed(eventType, url string, fi FeedInfo) {
+    feed := newFeedInfoResponse(url, fi)
+    publish(feedEvent{Type: eventType, URL: url, Feed: &feed})
+}
+
+// changed tells if the difference between two infos is worth an event.
+func (fi FeedInfo) changed(old FeedInfo) bool {
+    return fi.VacanciesCount != old.VacanciesCount || fi.SizeBytes != old.SizeBytes || fi.status() != old.status()
+}
+
+// eventsHandler streams feed changes as Server-Sent Events.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+    rc := http.NewResponseController(w)
+    // the stream lasts as long as the client wants, whatever -write-timeout
+    rc.SetWriteDeadline(time.Time{})
+    ch := subscribe()
+    defer unsubscribe(ch)
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.WriteHeader(http.StatusOK)
+    if err := rc.Flush(); err != nil {
+        log.Printf("Error starting event stream: %v\n", err)
+        return
+    }
+    heartbeat := time.NewTicker(eventsHeartbeat)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-heartbeat.C:
+            fmt.Fprint(w, ": heartbeat\n\n")
+        case e, ok := <-ch:
+            if !ok {
+                return
+            }
+            b, _ := json.Marshal(e)
+            fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+        }
+        if err := rc.Flush(); err != nil {
+            return
+        }
+    }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "math"
+    "strings"
+    "time"
+)
+
+var metricsExemplars = flag.Bool("metrics-exemplars", false, "attach trace ids of checks to the count duration histogram, for OpenMetrics scrapes with -otel-endpoint")
+
+// countBuckets are the upper bounds of the count duration histogram, in
+// seconds.
+var countBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, math.Inf(1)}
+
+// exemplar is the last count which fell into a bucket, with its trace.
+type exemplar struct {
+    TraceID string
+    Value   float64
+    At      time.Time
+}
+
+// durationHistogram is of the counts of all feeds, its bucket counts are
+// not cumulative.
+type durationHistogram struct {
+    counts    []int64
+    exemplars []exemplar
+    Count     int64
+    Sum       float64
+}
+
+// countHistogram is guarded by mu.
+var countHistogram = durationHistogram{
+    counts:    make([]int64, len(countBuckets)),
+    exemplars: make([]exemplar, len(countBuckets)),
+}
+
+// observe adds a count, traceID is empty when tracing is disabled.
+func (h *durationHistogram) observe(d time.Duration, traceID string) {
+    v := d.Seconds()
+    h.Count++
+    h.Sum += v
+    for i, le := range countBuckets {
+        if v <= le {
+            h.counts[i]++
+            if traceID != "" {
+                h.exemplars[i] = exemplar{TraceID: traceID, Value: v, At: clock.Now()}
+            }
+            return
+        }
+    }
+}
+
+// write appends the histogram in the text format, exemplars are only valid
+// in OpenMetrics.
+func (h *durationHistogram) write(b *strings.Builder, withExemplars bool) {
+    b.WriteString("# HELP feeds_count_duration_seconds Duration of vacancies counts of all feeds.\n")
+    b.WriteString("# TYPE feeds_count_duration_seconds histogram\n")
+    var cumulative int64
+    for i, le := range countBuckets {
+        cumulative += h.counts[i]
+        bound := "+Inf"
+        if !math.IsInf(le, 1) {
+            bound = fmt.Sprintf("%g", le)
+        }
+        fmt.Fprintf(b, "feeds_count_duration_seconds_bucket{le=\"%s\"} %d", bound, cumulative)
+        if e := h.exemplars[i]; withExemplars && e.TraceID != "" {
+            fmt.Fprintf(b, " # {trace_id=\"%s\"} %g %.3f", e.TraceID, e.Value, float64(e.At.UnixMilli())/1000)
+        }
+        b.WriteString("\n")
+    }
+    fmt.Fprintf(b, "feeds_count_duration_seconds_sum %g\n", h.Sum)
+    fmt.Fprintf(b, "feeds_count_duration_seconds_count %d\n", h.Count)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type exportRow struct {
+    URL            string  `json:"url"`
+    Status         string  `json:"status"`
+    SizeText       string  `json:"sizeText"`
+    SizeBytes      int64   `json:"sizeBytes"`
+    VacanciesCount int64   `json:"vacanciesCount"`
+    Estimated      bool    `json:"estimated,omitempty"`
+    LivenessOnly   bool    `json:"livenessOnly,omitempty"`
+    PartsFailed    int     `json:"partsFailed,omitempty"`
+    CountDuration  float64 `json:"countDurationSeconds"`
+    // CountDelta is the change since the previous count, CountDeltaPerHour
+    // its rate.
+    CountDelta        *int64   `json:"countDelta,omitempty"`
+    CountDeltaPerHour *float64 `json:"countDeltaPerHour,omitempty"`
+    // CountDurationP50 and others are quantiles of the recent counts.
+    CountDurationP50 float64 `json:"countDurationP50Seconds"`
+    CountDurationP95 float64 `json:"countDurationP95Seconds"`
+    CountDurationP99 float64 `json:"countDurationP99Seconds"`
+    // Reliability is the percentage of successful checks within the
+    // reliability window, nil before any.
+    Reliability  *float64 `json:"reliability,omitempty"`
+    ChecksOK     int64    `json:"checksOK"`
+    ChecksFailed int64    `json:"checksFailed"`
+    // Timeout is the effective request timeout of the feed, 0 - none.
+    Timeout         float64    `json:"timeoutSeconds"`
+    LastRequestedAt *time.Time `json:"lastRequestedAt,omitempty"`
+    UpdatedAt       *time.Time `json:"updatedAt,omitempty"`
+    CountedAt       *time.Time `json:"countedAt,omitempty"`
+    GeneratedAt     *time.Time `json:"generatedAt,omitempty"`
+    FailureSince    *time.Time `json:"failureSince,omitempty"`
+    FailureKind     string     `json:"failureKind,omitempty"`
+    LastError       string     `json:"lastError,omitempty"`
+    LastErrorAt     *time.Time `json:"lastErrorAt,omitempty"`
+    Tags            []string   `json:"tags,omitempty"`
+    Breaker         string     `json:"breaker,omitempty"`
+    Frozen          bool       `json:"frozen,omitempty"`
+    Pinned          bool       `json:"pinned,omitempty"`
+    Warnings        []string   `json:"warnings,omitempty"`
+}
+
+var exportHeader = []string{
+    "url", "status", "size_text", "size_bytes", "vacancies_count", "count_duration_seconds",
+    "last_requested_at", "updated_at", "counted_at", "generated_at", "failure_since", "tags", "breaker", "frozen", "pinned",
+    "estimated", "count_duration_p50_seconds", "count_duration_p95_seconds", "count_duration_p99_seconds",
+    "reliability_percent", "checks_ok", "checks_failed", "timeout_seconds", "liveness_only", "failure_kind",
+    "last_error", "last_error_at", "parts_failed", "count_delta", "count_delta_per_hour", "warnings",
+}
+
+func (row exportRow) csvRecord() []string {
+    return []string{
+        row.URL,
+        row.Status,
+        row.SizeText,
+        strconv.FormatInt(row.SizeBytes, 10),
+        strconv.FormatInt(row.VacanciesCount, 10),
+        strconv.FormatFloat(row.CountDuration, 'f', 3, 64),
+        csvTime(row.LastRequestedAt),
+        csvTime(row.UpdatedAt),
+        csvTime(row.CountedAt),
+        csvTime(row.GeneratedAt),
+        csvTime(row.FailureSince),
+        strings.Join(row.Tags, " "),
+        row.Breaker,
+        strconv.FormatBool(row.Frozen),
+        strconv.FormatBool(row.Pinned),
+        strconv.FormatBool(row.Estimated),
+        strconv.FormatFloat(row.CountDurationP50, 'f', 3, 64),
+        strconv.FormatFloat(row.CountDurationP95, 'f', 3, 64),
+        strconv.FormatFloat(row.CountDurationP99, 'f', 3, 64),
+        csvPercent(row.Reliability),
+        strconv.FormatInt(row.ChecksOK, 10),
+        strconv.FormatInt(row.ChecksFailed, 10),
+        strconv.FormatFloat(row.Timeout, 'f', 3, 64),
+        strconv.FormatBool(row.LivenessOnly),
+        row.FailureKind,
+        row.LastError,
+        csvTime(row.LastErrorAt),
+        strconv.Itoa(row.PartsFailed),
+        csvInt(row.CountDelta),
+        csvFloat(row.CountDeltaPerHour),
+        strings.Join(row.Warnings, " "),
+    }
+}
+
+func csvPercent(p *float64) string {
+    if p == nil {
+        return ""
+    }
+    return strconv.FormatFloat(*p, 'f', 1, 64)
+}
+
+func csvInt(n *int64) string {
+    if n == nil {
+        return ""
+    }
+    return strconv.FormatInt(*n, 10)
+}
+
+func csvFloat(f *float64) string {
+    if f == nil {
+        return ""
+    }
+    return strconv.FormatFloat(*f, 'f', 3, 64)
+}
+
+func csvTime(t *time.Time) string {
+    if t == nil {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
+
+// optionalTime omits zero times from the output.
+func optionalTime(t time.Time) *time.Time {
+    if t.IsZero() {
+        return nil
+    }
+    return &t
+}
+
+// exportSnapshot copies the state of every monitored feed selected by the
+// filter, feeds which are not counted yet are reported with "counting" status.
+func exportSnapshot(filter tagFilter) []exportRow {
+    mu.RLock()
+    defer mu.RUnlock()
+    rows := make([]exportRow, 0, len(updaters))
+    for url, requested := range updaters {
+        opts := options[url]
+        if !filter.matches(opts.Tags) {
+            continue
+        }
+        row := exportRow{URL: url, Status: "counting", LastRequestedAt: optionalTime(requested), Tags: opts.Tags, Pinned: opts.Pinned}
+        row.Reliability, row.ChecksOK, row.ChecksFailed = reliability(url, clock.Now())
+        row.Timeout = opts.timeout().Seconds()
+        // a feed failing before its first count has no info yet
+        if episodes := failureHistory[url]; len(episodes) > 0 && episodes[len(episodes)-1].End == nil {
+            row.FailureKind = string(episodes[len(episodes)-1].Kind)
+            row.LastError = episodes[len(episodes)-1].LastError
+        }
+        if fi, ok := info[url]; ok {
+            row.Status = fi.status()
+            row.SizeText = fi.SizeText
+            row.SizeBytes = fi.SizeBytes
+            row.VacanciesCount = fi.VacanciesCount
+            row.Estimated = fi.Estimated
+            row.LivenessOnly = fi.LivenessOnly
+            row.PartsFailed = fi.PartsFailed
+            row.CountDelta, row.CountDeltaPerHour = fi.countDelta()
+            row.CountDuration = fi.CountDuration.Seconds()
+            if window, ok := countDurations[url]; ok {
+                q := window.quantiles(summaryQuantiles...)
+                row.CountDurationP50, row.CountDurationP95, row.CountDurationP99 = q[0].Seconds(), q[1].Seconds(), q[2].Seconds()
+            }
+            row.UpdatedAt = optionalTime(fi.UpdatedAt)
+            row.CountedAt = optionalTime(fi.CountedAt)
+            row.GeneratedAt = optionalTime(fi.GeneratedAt)
+            row.FailureSince = optionalTime(fi.FailureSince)
+            if fi.FailureKind != "" {
+                row.FailureKind = string(fi.FailureKind)
+            }
+            if fi.LastError != "" {
+                row.LastError, row.LastErrorAt = fi.LastError, optionalTime(fi.LastErrorAt)
+            }
+            row.Breaker = string(fi.Breaker)
+            row.Frozen = fi.frozen()
+            row.Warnings = fi.Warnings
+        }
+        rows = append(rows, row)
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].URL < rows[j].URL })
+    return rows
+}
+
+// rowCompare compares export rows by a sort field.
+var rowCompare = map[string]func(a, b exportRow) int{
+    "url":          func(a, b exportRow) int { return 0 },
+    "vacancies":    func(a, b exportRow) int { return cmpInt64(a.VacanciesCount, b.VacanciesCount) },
+    "size":         func(a, b exportRow) int { return cmpInt64(a.SizeBytes, b.SizeBytes) },
+    "lastUpdated":  func(a, b exportRow) int { return cmpTime(a.UpdatedAt, b.UpdatedAt) },
+    "failureSince": func(a, b exportRow) int { return cmpTime(a.FailureSince, b.FailureSince) },
+}
+
+func cmpInt64(a, b int64) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    }
+    return 0
+}
+
+// cmpTime orders missing times first.
+func cmpTime(a, b *time.Time) int {
+    var ta, tb time.Time
+    if a != nil {
+        ta = *a
+    }
+    if b != nil {
+        tb = *b
+    }
+    return ta.Compare(tb)
+}
+
+// sortRows sorts rows by ?sort=field&order=asc|desc, url sorted rows are
+// left as they are.
+func sortRows(rows []exportRow, values url.Values) error {
+    field, order := values.Get("sort"), values.Get("order")
+    if field == "" {
+        field = "url"
+    }
+    cmp, ok := rowCompare[field]
+    if !ok {
+        return fmt.Errorf("unknown sort field %q, expected vacancies, size, lastUpdated, failureSince or url", field)
+    }
+    if order != "" && order != "asc" && order != "desc" {
+        return fmt.Errorf("unknown order %q, expected asc or desc", order)
+    }
+    sort.SliceStable(rows, func(i, j int) bool {
+        c := cmp(rows[i], rows[j])
+        if c == 0 {
+            c = strings.Compare(rows[i].URL, rows[j].URL)
+        }
+        if order == "desc" {
+            return c > 0
+        }
+        return c < 0
+    })
+    return nil
+}
+
+// exportHandler serves /export?format=json|csv&sort=&order= as of /feeds.
+// The snapshot is taken under the lock, rows are written one by one
+// afterwards.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "json"
+    }
+    if format != "json" && format != "csv" {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(fmt.Sprintf("unknown export format %q, expected json or csv", format)))
+        return
+    }
+    filter, err := parseTagFilter(r.URL.Query())
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error()))
+        return
+    }
+    rows := exportSnapshot(filter)
+    if err := sortRows(rows, r.URL.Query()); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error()))
+        return
+    }
+
+    if format == "csv" {
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+        cw := csv.NewWriter(w)
+        cw.Write(exportHeader)
+        for _, row := range rows {
+            cw.Write(row.csvRecord())
+        }
+        cw.Flush()
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write([]byte("["))
+    for i, row := range rows {
+        if i > 0 {
+            w.Write([]byte(","))
+        }
+        b, _ := json.Marshal(row)
+        w.Write(b)
+    }
+    w.Write([]byte("]\n"))
+}
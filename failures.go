@@ -0,0 +1,72 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "regexp"
+    "time"
+)
+
+var failureHistoryLen = flag.Int("failure-history", 20, "failure episodes kept per feed")
+
+// failureEpisode is a run of failed checks of a feed, End is nil while it
+// lasts.
+type failureEpisode struct {
+    Start     time.Time   `json:"start"`
+    End       *time.Time  `json:"end,omitempty"`
+    Kind      failureKind `json:"kind"`
+    LastError string      `json:"lastError"`
+}
+
+// failureHistory holds the recent failure episodes of feeds, the oldest
+// first, guarded by mu.
+var failureHistory = make(map[string][]failureEpisode)
+
+// recordFailure adds a failed check of url to its history, mu must be held.
+func recordFailure(url string, kind failureKind, err error, now time.Time) {
+    episodes := failureHistory[url]
+    if n := len(episodes); n > 0 && episodes[n-1].End == nil {
+        episodes[n-1].Kind = kind
+        episodes[n-1].LastError = redactError(err)
+        return
+    }
+    episodes = append(episodes, failureEpisode{Start: now, Kind: kind, LastError: redactError(err)})
+    if n := *failureHistoryLen; n > 0 && len(episodes) > n {
+        episodes = append([]failureEpisode(nil), episodes[len(episodes)-n:]...)
+    }
+    failureHistory[url] = episodes
+}
+
+// urlCredentials are the user info of urls in error messages.
+var urlCredentials = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s]+@`)
+
+// redactError is the message of err safe to show, credentials of urls in it
+// replaced.
+func redactError(err error) string {
+    return urlCredentials.ReplaceAllString(err.Error(), "${1}[redacted]@")
+}
+
+// recordRecovery ends the current failure episode of url, mu must be held.
+func recordRecovery(url string, now time.Time) {
+    episodes := failureHistory[url]
+    if n := len(episodes); n > 0 && episodes[n-1].End == nil {
+        episodes[n-1].End = &now
+    }
+}
+
+// failureEpisodes returns a copy of the history of url, mu must be held.
+func failureEpisodes(url string) []failureEpisode {
+    return append([]failureEpisode(nil), failureHistory[url]...)
+}
+
+// writeFailures appends the episodes to a plain text response, a line each.
+func writeFailures(w io.Writer, failures []failureEpisode) {
+    for _, e := range failures {
+        end := "now"
+        if e.End != nil {
+            end = e.End.UTC().Format(time.RFC3339)
+        }
+        fmt.Fprintf(w, "\nfailure %s - %s, %s: %s", e.Start.UTC().Format(time.RFC3339), end, e.Kind, e.LastError)
+    }
+}
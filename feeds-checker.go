@@ -1,178 +1,1770 @@
 package main
 
 import (
+    "bufio"
+    "bytes"
+    "compress/flate"
     "compress/gzip"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/xml"
+    "errors"
+    "flag"
     "fmt"
+    "io"
     "io/ioutil"
     "log"
+    "net"
     "net/http"
-    "regexp"
+    "strconv"
+    "strings"
+    "sync"
     "time"
 )
 
 type FeedInfo struct {
-    Stat           string
-    Size           string
+    // Stat is the raw stat of the last count, cut to trimmedStatBytes with
+    // the StatFields dropped under -max-aux-memory pressure.
+    Stat string
+    // StatFields are the "key:value" lines of the stat, StatCount - the
+    // vacancies count it tells, if any. The map isn't modified once set.
+    StatFields map[string]string
+    StatCount  *int64
+    // SizeText is the archive size as written in the stat, SizeBytes - in
+    // bytes, it's the one compared to detect changes.
+    SizeText  string
+    SizeBytes int64
+    // ETag identifies the archive version of a feed which size is probed
+    // without a stat and only the ETag is known.
+    ETag           string
     VacanciesCount int64
-    FailureSince   time.Time
+    // RawCount is the number of vacancy elements regardless of the attribute
+    // rule, VacanciesCount are the ones passing it.
+    RawCount     int64
+    FailureSince time.Time
+    // Jitter is the fraction of -timeout-jitter the failure timeout of the
+    // feed is extended by, fixed by its url.
+    Jitter float64
+    // GeneratedAt is the best known time the feed archive was generated at.
+    // Zero if the feed doesn't tell.
+    GeneratedAt time.Time
+    // UpdatedAt is the time of the last successful check, CountedAt - of
+    // the last vacancies count which took CountDuration.
+    UpdatedAt     time.Time
+    CountedAt     time.Time
+    CountDuration time.Duration
+    // PendingSizeBytes is the latest size seen since CountedAt, when it
+    // differs from SizeBytes but it's too early to recount.
+    PendingSizeBytes int64
+    // RecountRequested makes the next check recount the feed whatever its
+    // size, e.g. after its counting options changed.
+    RecountRequested bool
+    // DownloadedBytes is the archive size read by the last count.
+    // SizeBudgetExceeded is set when it was over the feed's MaxSize, Chunked
+    // when the archive response had no Content-Length.
+    DownloadedBytes    int64
+    SizeBudgetExceeded bool
+    Chunked            bool
+    // Refreshing is set while the feed is being recounted, the info is of
+    // the previous count meanwhile. PartialCount and PartialBytes are the
+    // progress of a long recount.
+    Refreshing   bool
+    PartialCount int64
+    PartialBytes int64
+    // FailureKind classifies the last failure while FailureSince is set,
+    // Breaker is the state of the feed circuit breaker then. RetryAt is when
+    // the server asked with Retry-After to be checked again.
+    FailureKind failureKind
+    Breaker     breakerState
+    RetryAt     time.Time
+    // LastError is the redacted message of the last failed check at
+    // LastErrorAt, kept after the feed recovers.
+    LastError   string
+    LastErrorAt time.Time
+    // ContentHash is SHA-256 of the decompressed feed of the last count,
+    // UnchangedSince - since when the content is the same. A feed which
+    // content doesn't change for FrozenAfter is reported frozen.
+    ContentHash    string
+    UnchangedSince time.Time
+    // ContentLanguage is the language of the feed as its server tells.
+    ContentLanguage string
+    // CacheHits are checks which found the size unchanged and didn't
+    // recount, CacheMisses - recounts.
+    CacheHits   int64
+    CacheMisses int64
+    // VerifiedCount is the result of the second count of a feed with the
+    // verify option, CountMismatch is set when it differs.
+    VerifiedCount *int64
+    CountMismatch bool
+    // Estimated is set when VacanciesCount is extrapolated from a sampled
+    // prefix of the archive.
+    Estimated bool
+    // LivenessOnly feeds aren't counted, VacanciesCount is 0 and not shown.
+    LivenessOnly bool
+    // PreviousCount is the count before the last one, done at
+    // PreviousCountedAt, zero before the second count.
+    PreviousCount     int64
+    PreviousCountedAt time.Time
+    // Parts are the counts of the parts of an index feed, VacanciesCount is
+    // their sum. PartsFailed of them failed and aren't in it.
+    Parts       []indexPart
+    PartsFailed int
+    // Pages are counted of a paged feed, PageCycle is set when their next
+    // links lead back to a counted one, PagesTruncated when there were more
+    // than -max-feed-pages.
+    Pages          int
+    PageCycle      bool
+    PagesTruncated bool
+    // TrailerCorrupt is set when the archive ended with a broken gzip
+    // trailer after the complete feed, tolerated by the feed's option.
+    TrailerCorrupt bool
+    // AvgThroughput and PeakThroughput are the rates the decompressed feed
+    // was parsed at by the last count, in bytes per second.
+    AvgThroughput  float64
+    PeakThroughput float64
+    // StatHost and ArchiveHost are the hosts which served the stat and the
+    // archive of the last count after redirects, HostMismatch is set when
+    // they differ.
+    StatHost     string
+    ArchiveHost  string
+    HostMismatch bool
+    // Warnings are the validation classes with the "warn" policy which found
+    // something since the last count.
+    Warnings []string
+}
+
+// failureKind tells how a failed check should be handled.
+type failureKind string
+
+const (
+    // failureDead - the feed is gone (404, 410), it's evicted after
+    // DeadFeedTimeout.
+    failureDead failureKind = "dead"
+    // failureArchiveUnavailable - the stat answers but the archive is 404
+    // or 410, e.g. a misdeploy, it's evicted after
+    // ArchiveUnavailableTimeout.
+    failureArchiveUnavailable failureKind = "archive-unavailable"
+    // failureTransient - the server is overloaded or down (5xx, 429, a
+    // timeout), the feed is checked with a backoff.
+    failureTransient failureKind = "transient"
+    // failureWrongDocument - the archive isn't a feed, e.g. a gzipped
+    // error page.
+    failureWrongDocument failureKind = "wrong-document"
+    // failureHostMismatch - the stat and the archive are served by
+    // different hosts with the "fail" RedirectHostPolicy.
+    failureHostMismatch failureKind = "host-mismatch"
+    // failureEmpty - the feed has no vacancies and it's treated as a
+    // failure.
+    failureEmpty failureKind = "empty"
+    // failureFrozen - the content didn't change for FrozenAfter with the
+    // "fail" frozen policy.
+    failureFrozen failureKind = "frozen"
+    // failureCountMismatch - two counts of a verified feed disagree with
+    // the "fail" count-mismatch policy.
+    failureCountMismatch failureKind = "count-mismatch"
+    // failureEmptyBody - the archive response has no body at all.
+    failureEmptyBody failureKind = "empty-body"
+    // failureCorrupt - the archive fails its gzip checksum or doesn't
+    // inflate, it's damaged in transfer or storage rather than malformed.
+    failureCorrupt failureKind = "corrupt-archive"
+    failureOther failureKind = "error"
+)
+
+// hostMismatchError is returned when the stat and the archive of a feed
+// resolve to different hosts.
+type hostMismatchError struct {
+    StatHost, ArchiveHost string
+}
+
+func (e *hostMismatchError) Error() string {
+    return fmt.Sprintf("stat is served by %s, archive by %s", e.StatHost, e.ArchiveHost)
+}
+
+// wrongRootError is returned for a document with an unexpected root element.
+type wrongRootError struct {
+    Root, Expected xml.Name
+}
+
+func (e *wrongRootError) Error() string {
+    return fmt.Sprintf("unexpected root element %s, expected %s", formatName(e.Root), formatName(e.Expected))
+}
+
+func formatName(n xml.Name) string {
+    if n.Local == "" {
+        return "none"
+    }
+    if n.Space == "" {
+        return n.Local
+    }
+    return "{" + n.Space + "}" + n.Local
+}
+
+// afterRootError is a failure to read a feed past its root element, all the
+// feed is parsed then.
+type afterRootError struct {
+    Err error
+}
+
+func (e *afterRootError) Error() string {
+    return fmt.Sprintf("after the root element: %v", e.Err)
+}
+
+func (e *afterRootError) Unwrap() error {
+    return e.Err
+}
+
+// contentTypeError is a response of a type not allowed, e.g. an HTML error
+// page answered with 200.
+type contentTypeError struct {
+    URL         string
+    ContentType string
+}
+
+func (e *contentTypeError) Error() string {
+    return fmt.Sprintf("Got unexpected Content-Type %q from %s", e.ContentType, e.URL)
+}
+
+// statusError is a non-2xx response from a feed.
+type statusError struct {
+    URL    string
+    Status string
+    Code   int
+    // Archive is set for the archive download, the stat answered then.
+    Archive bool
+    // RetryAfter is when a 429 or 503 asked to come back, zero if it didn't.
+    RetryAfter time.Time
+}
+
+func (e *statusError) Error() string {
+    return fmt.Sprintf("Got '%v' from %s", e.Status, e.URL)
+}
+
+func classifyFailure(err error) failureKind {
+    var wre *wrongRootError
+    var cte *contentTypeError
+    if errors.As(err, &wre) || errors.As(err, &cte) || errors.Is(err, errNotFeed) || errors.Is(err, errNoParts) {
+        return failureWrongDocument
+    }
+    if errors.Is(err, errEmptyFeed) {
+        return failureEmpty
+    }
+    if errors.Is(err, errFrozen) {
+        return failureFrozen
+    }
+    var cme *countMismatchError
+    if errors.As(err, &cme) {
+        return failureCountMismatch
+    }
+    if errors.Is(err, errEmptyBody) {
+        return failureEmptyBody
+    }
+    var cie flate.CorruptInputError
+    if errors.Is(err, gzip.ErrChecksum) || errors.As(err, &cie) {
+        return failureCorrupt
+    }
+    var hme *hostMismatchError
+    if errors.As(err, &hme) {
+        return failureHostMismatch
+    }
+    var ne net.Error
+    if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+        return failureTransient
+    }
+    var se *statusError
+    if !errors.As(err, &se) {
+        return failureOther
+    }
+    switch {
+    case (se.Code == http.StatusNotFound || se.Code == http.StatusGone) && se.Archive:
+        return failureArchiveUnavailable
+    case se.Code == http.StatusNotFound || se.Code == http.StatusGone:
+        return failureDead
+    case se.Code == http.StatusTooManyRequests || se.Code >= 500:
+        return failureTransient
+    default:
+        return failureOther
+    }
+}
+
+// setProgress reports the partial count of the feed being recounted.
+func setProgress(feeds map[string]FeedInfo, url string, count, downloaded int64) {
+    mu.Lock()
+    defer mu.Unlock()
+    if fi, ok := feeds[url]; ok {
+        fi.PartialCount, fi.PartialBytes = count, downloaded
+        feeds[url] = fi
+    }
+}
+
+// setRefreshing marks the known info about url as being recounted.
+func setRefreshing(feeds map[string]FeedInfo, url string, refreshing bool) {
+    mu.Lock()
+    defer mu.Unlock()
+    if fi, ok := feeds[url]; ok {
+        fi.Refreshing = refreshing
+        feeds[url] = fi
+    }
+}
+
+// sameSize tells if the archive is the one last counted.
+func (fi FeedInfo) sameSize(size feedSize) bool {
+    return fi.SizeBytes == size.Bytes && fi.ETag == size.ETag
+}
+
+func (fi FeedInfo) frozen() bool {
+    frozenAfter := cfg().FrozenAfter.Duration
+    return frozenAfter > 0 && !fi.UnchangedSince.IsZero() && since(fi.UnchangedSince) > frozenAfter
+}
+
+// countDelta is the change of the count since the previous one and its rate
+// per hour, nil before the second count.
+func (fi FeedInfo) countDelta() (delta *int64, perHour *float64) {
+    if fi.PreviousCountedAt.IsZero() || fi.LivenessOnly {
+        return nil, nil
+    }
+    d := fi.VacanciesCount - fi.PreviousCount
+    delta = &d
+    if elapsed := fi.CountedAt.Sub(fi.PreviousCountedAt); elapsed > 0 {
+        rate := float64(d) / elapsed.Hours()
+        perHour = &rate
+    }
+    return delta, perHour
+}
+
+func (fi FeedInfo) status() string {
+    switch {
+    case fi.FailureSince.IsZero():
+        return "ok"
+    case since(fi.FailureSince) > cfg().FailureTimeout.Duration+fi.jitter():
+        return "failed"
+    default:
+        return "failing"
+    }
+}
+
+type countResult struct {
+    VacanciesCount     int64
+    RawCount           int64
+    ContentHash        string
+    ContentLanguage    string
+    ArchiveHost        string
+    Estimated          bool
+    // PlainFallback is set when an archive which should be gzip was parsed
+    // as plain XML.
+    PlainFallback      bool
+    // Parts are the counts of the parts of an index feed, PartsFailed were
+    // not counted.
+    Parts              []indexPart
+    PartsFailed        int
+    // NextPage is the page the counted one links to, Pages are counted of a
+    // paged feed. PageCycle is set when the links lead back to a counted
+    // page, PagesTruncated when there are more than -max-feed-pages.
+    NextPage           string
+    Pages              int
+    PageCycle          bool
+    PagesTruncated     bool
+    TrailerCorrupt     bool
+    AvgThroughput      float64
+    PeakThroughput     float64
+    GeneratedAt        time.Time
+    // DownloadedBytes are read of the archive, Chunked is set when the
+    // response didn't tell its length.
+    DownloadedBytes    int64
+    Chunked            bool
+    SizeBudgetExceeded bool
 }
 
 const FeedsLimit = 32
 
-func getFeedSize(url string) (size string, stat []byte) {
+// withRequestTimeout limits a request to a feed by its timeout, which
+// overrides the configured one.
+func withRequestTimeout(ctx context.Context, opts FeedOptions) (context.Context, context.CancelFunc) {
+    if t := opts.timeout(); t > 0 {
+        return context.WithTimeout(ctx, t)
+    }
+    return context.WithCancel(ctx)
+}
+
+// newFeedRequest prepares a request to a feed with the feed's headers.
+func newFeedRequest(ctx context.Context, url string, opts FeedOptions) (*http.Request, error) {
+    req, err := http.NewRequestWithContext(withProxy(ctx, opts.Proxy), http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    for name, value := range opts.Headers {
+        req.Header.Set(name, value)
+    }
+    if opts.Language != "" {
+        req.Header.Set("Accept-Language", opts.Language)
+    }
+    injectTraceContext(ctx, req)
+    return req, nil
+}
+
+// getFeedSize fetches the stat of the feed, host is the one which served it
+// after redirects.
+func getFeedSize(ctx context.Context, url string, opts FeedOptions) (size feedSize, stat []byte, host string, err error) {
+    ctx, span := startSpan(ctx, "stat fetch")
+    defer func() {
+        span.setAttr("size", size.Bytes)
+        span.finish(err)
+    }()
+    span.setAttr("url", url)
+
+    ctx, cancel := withRequestTimeout(ctx, opts)
+    defer cancel()
     statUrl := fmt.Sprintf("%s?stat", url)
-    res, err := http.Get(statUrl)
+    req, err := newFeedRequest(ctx, statUrl, opts)
     if err != nil {
-        log.Printf("Error fetching stat from %s: %v\n", statUrl, err)
-        return
+        return size, nil, "", fmt.Errorf("Error fetching stat from %s: %v", statUrl, err)
+    }
+    res, err := doFeedRequest(req)
+    if err != nil {
+        return size, nil, "", fmt.Errorf("Error fetching stat from %s: %v", statUrl, err)
     }
     defer res.Body.Close()
+    host = res.Request.URL.Host
     if res.StatusCode >= 300 {
-        log.Printf("Got '%v' from %s\n", res.Status, statUrl)
-        return
+        return size, nil, host, &statusError{URL: statUrl, Status: res.Status, Code: res.StatusCode, RetryAfter: retryAfter(res)}
+    }
+    if ct := res.Header.Get("Content-Type"); !contentTypeAllowed(ct, cfg().StatContentTypes) {
+        if err := validate(ctx, url, opts, validationContentType, &contentTypeError{URL: statUrl, ContentType: ct}); err != nil {
+            return size, nil, host, err
+        }
     }
     stat, err = ioutil.ReadAll(res.Body)
     if err != nil {
-        log.Printf("Error fetching stat from %s: %v\n", statUrl, err)
-        return
+        return size, nil, host, fmt.Errorf("Error fetching stat from %s: %v", statUrl, err)
+    }
+
+    size, err = extractSize(stat)
+    if err != nil {
+        return feedSize{}, stat, host, fmt.Errorf("Error parsing stat from %s: %v", statUrl, err)
+    }
+    return size, stat, host, nil
+}
+
+// getFeedSizeOrProbe gets the size from the stat, falling back to probing
+// the archive if the feed has the sizeFallback option.
+func getFeedSizeOrProbe(ctx context.Context, url string, opts FeedOptions) (size feedSize, stat []byte, host string, err error) {
+    size, stat, host, err = getFeedSize(ctx, url, opts)
+    if err == nil || !opts.SizeFallback || ctx.Err() != nil {
+        return size, stat, host, err
+    }
+    size, host, probeErr := probeSize(ctx, url, opts)
+    if probeErr != nil {
+        return feedSize{}, nil, host, fmt.Errorf("%w, probing the archive: %v", err, probeErr)
+    }
+    return size, []byte(size.Text), host, nil
+}
+
+// probeSize gets the archive size from Content-Length of a HEAD response,
+// from Content-Range of a GET of its first byte if HEAD tells nothing, or
+// just its ETag.
+func probeSize(ctx context.Context, url string, opts FeedOptions) (size feedSize, host string, err error) {
+    ctx, span := startSpan(ctx, "size probe")
+    defer func() {
+        span.setAttr("size", size.Bytes)
+        span.finish(err)
+    }()
+    span.setAttr("url", url)
+
+    ctx, cancel := withRequestTimeout(ctx, opts)
+    defer cancel()
+    req, err := newFeedRequest(ctx, url, opts)
+    if err != nil {
+        return size, "", err
+    }
+    req.Method = http.MethodHead
+    res, err := doFeedRequest(req)
+    if err != nil {
+        return size, "", err
+    }
+    res.Body.Close()
+    host = res.Request.URL.Host
+    etag := res.Header.Get("ETag")
+    if res.StatusCode < 300 && res.ContentLength >= 0 {
+        return feedSize{Text: fmt.Sprintf("Content-Length: %d", res.ContentLength), Bytes: res.ContentLength}, host, nil
     }
 
-    re := regexp.MustCompile(`size:(\d+) bytes`)
-    return string(re.FindSubmatch(stat)[1][:]), stat
+    req, err = newFeedRequest(ctx, url, opts)
+    if err != nil {
+        return size, host, err
+    }
+    req.Header.Set("Range", "bytes=0-0")
+    res, err = doFeedRequest(req)
+    if err != nil {
+        return size, host, err
+    }
+    // the server may ignore the range, don't download the whole archive
+    res.Body.Close()
+    host = res.Request.URL.Host
+    if res.StatusCode >= 300 {
+        return size, host, &statusError{URL: url, Status: res.Status, Code: res.StatusCode, RetryAfter: retryAfter(res)}
+    }
+    if v := res.Header.Get("ETag"); v != "" {
+        etag = v
+    }
+    if res.StatusCode == http.StatusPartialContent {
+        cr := res.Header.Get("Content-Range")
+        if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+            if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+                return feedSize{Text: "Content-Range: " + cr, Bytes: n}, host, nil
+            }
+        }
+    } else if res.ContentLength >= 0 {
+        return feedSize{Text: fmt.Sprintf("Content-Length: %d", res.ContentLength), Bytes: res.ContentLength}, host, nil
+    }
+    if etag != "" {
+        return feedSize{Text: "ETag: " + etag, ETag: etag}, host, nil
+    }
+    return size, host, fmt.Errorf("no Content-Length, Content-Range or ETag")
 }
 
-func feedIsAlive(url string) bool {
-    size, _ := getFeedSize(url)
-    return size != ""
+func feedIsAlive(ctx context.Context, url string, opts FeedOptions) bool {
+    _, _, _, err := getFeedSizeOrProbe(withCookieJar(ctx, opts), url, opts)
+    if err != nil {
+        log.Println(err)
+    }
+    return err == nil
 }
 
-func updateInfoIfNeed(url string, feeds map[string]FeedInfo) error {
-    size, stat := getFeedSize(url)
-    if size == "" {
-        return fmt.Errorf("Error getting feed %s size - skip info update\n", url)
+func updateInfoIfNeed(ctx context.Context, url string, feeds map[string]FeedInfo) (err error) {
+    ctx, span := startSpan(ctx, "check")
+    defer func() { span.finish(err) }()
+    span.setAttr("url", url)
+    ctx, findings := withFindings(ctx)
+
+    mu.RLock()
+    opts := options[url]
+    mu.RUnlock()
+    ctx = withCookieJar(ctx, opts)
+    size, stat, statHost, err := getFeedSizeOrProbe(ctx, url, opts)
+    if err != nil {
+        return fmt.Errorf("Error getting feed %s size - skip info update: %w", url, err)
     }
+    span.setAttr("size", size.Bytes)
 
+    mu.RLock()
     fi, ok := feeds[url]
-    if !ok || fi.Size != size {
-        log.Printf("counting vacancies for %s", url)
-        vc, err := countVacancies(url)
+    mu.RUnlock()
+    minRecountInterval := cfg().MinRecountInterval.Duration
+    // countDuration is set when the feed is recounted
+    var countDuration time.Duration
+    if ok && !fi.RecountRequested && !fi.sameSize(size) && since(fi.CountedAt) < minRecountInterval {
+        if fi.PendingSizeBytes != size.Bytes {
+            log.Printf("%s size changed to %d bytes, recount postponed till %s", url, size.Bytes,
+                fi.CountedAt.Add(minRecountInterval).Format(time.RFC3339))
+        }
+        fi.PendingSizeBytes = size.Bytes
+        recountsPostponed.Add(1)
+    } else if ok && !fi.RecountRequested && fi.sameSize(size) {
+        // the size is the same, so is the count
+        fi.CacheHits++
+        cacheHits.Add(1)
+    } else {
+        cacheMisses.Add(1)
+        var cr, verified countResult
+        var err error
+        if opts.LivenessOnly {
+            // the stat answered, the archive is probed at most
+            if opts.ProbeArchive {
+                log.Printf("probing archive of %s", url)
+                cr, err = probeArchive(ctx, url, opts)
+            }
+        } else {
+            log.Printf("counting vacancies for %s", url)
+            setRefreshing(feeds, url, true)
+            started := time.Now()
+            countCtx := withArchiveSize(ctx, size.Bytes)
+            cr, err = countVacancies(countCtx, url, opts, func(count, downloaded int64) {
+                setProgress(feeds, url, count, downloaded)
+            })
+            countDuration = time.Since(started)
+            // the second count should be equal unless the feed content is
+            // nondeterministic or malformed
+            if err == nil && opts.Verify {
+                verified, err = countVacancies(countCtx, url, opts, nil)
+            }
+            if err == nil && opts.Verify && verified.VacanciesCount != cr.VacanciesCount {
+                err = validate(ctx, url, opts, validationCountMismatch, &countMismatchError{Count: cr.VacanciesCount, Verified: verified.VacanciesCount})
+            }
+            if err == nil && cr.VacanciesCount == 0 {
+                err = validate(ctx, url, opts, validationEmpty, errEmptyFeed)
+            }
+        }
+        // a redirect of one of them only may be a misconfiguration or a
+        // hijack, yet multi-CDN setups do it legitimately
+        hostMismatch := err == nil && opts.policy(validationRedirectHost) != policyOff && cr.ArchiveHost != "" && cr.ArchiveHost != statHost
+        if hostMismatch {
+            err = validate(ctx, url, opts, validationRedirectHost, &hostMismatchError{StatHost: statHost, ArchiveHost: cr.ArchiveHost})
+        }
+        if cr.SizeBudgetExceeded {
+            // over the budget the count is aborted with the "fail" policy
+            validate(ctx, url, opts, validationSizeBudget, fmt.Errorf("over the %d bytes size budget", opts.MaxSize))
+        }
         if err != nil {
-            return fmt.Errorf("Error counting vacancies: %v", err)
+            mu.Lock()
+            if fi, ok := feeds[url]; ok {
+                fi.Refreshing = false
+                fi.PartialCount, fi.PartialBytes = 0, 0
+                if errors.Is(err, errSizeBudgetExceeded) {
+                    fi.SizeBudgetExceeded = true
+                }
+                feeds[url] = fi
+            }
+            mu.Unlock()
+            return fmt.Errorf("Error counting vacancies: %w", err)
+        }
+        span.setAttr("count", cr.VacanciesCount)
+        statFields := parseStatFields(stat)
+        prev := fi
+        fi = FeedInfo{
+            Stat:               string(stat[:]),
+            StatFields:         statFields,
+            StatCount:          statCount(statFields),
+            SizeText:           size.Text,
+            SizeBytes:          size.Bytes,
+            ETag:               size.ETag,
+            VacanciesCount:     cr.VacanciesCount,
+            RawCount:           cr.RawCount,
+            GeneratedAt:        cr.GeneratedAt,
+            CountedAt:          clock.Now(),
+            CountDuration:      countDuration,
+            DownloadedBytes:    cr.DownloadedBytes,
+            SizeBudgetExceeded: cr.SizeBudgetExceeded,
+            Chunked:            cr.Chunked,
+            ContentHash:        cr.ContentHash,
+            ContentLanguage:    cr.ContentLanguage,
+            CacheHits:          prev.CacheHits,
+            CacheMisses:        prev.CacheMisses + 1,
+            LastError:          prev.LastError,
+            LastErrorAt:        prev.LastErrorAt,
+            Estimated:          cr.Estimated,
+            TrailerCorrupt:     cr.TrailerCorrupt,
+            AvgThroughput:      cr.AvgThroughput,
+            PeakThroughput:     cr.PeakThroughput,
+            StatHost:           statHost,
+            ArchiveHost:        cr.ArchiveHost,
+            HostMismatch:       hostMismatch,
+            Jitter:             jitterFraction(url),
+            LivenessOnly:       opts.LivenessOnly,
+            Parts:              cr.Parts,
+            PartsFailed:        cr.PartsFailed,
+            Pages:              cr.Pages,
+            PageCycle:          cr.PageCycle,
+            PagesTruncated:     cr.PagesTruncated,
+        }
+        // the first count has nothing to compare with, a feed which wasn't
+        // counted before neither
+        if ok && !prev.CountedAt.IsZero() && !prev.LivenessOnly {
+            fi.PreviousCount, fi.PreviousCountedAt = prev.VacanciesCount, prev.CountedAt
+        }
+        fi.UnchangedSince = fi.CountedAt
+        // an estimated count hashes nothing
+        if ok && cr.ContentHash != "" && prev.ContentHash == cr.ContentHash && !prev.UnchangedSince.IsZero() {
+            fi.UnchangedSince = prev.UnchangedSince
         }
-        feeds[url] = FeedInfo{
-            Stat:           string(stat[:]),
-            Size:           size,
-            VacanciesCount: vc,
+        if opts.Verify && !opts.LivenessOnly {
+            fi.VerifiedCount = &verified.VacanciesCount
+            fi.CountMismatch = verified.VacanciesCount != cr.VacanciesCount && opts.policy(validationCountMismatch) != policyOff
+        }
+        if !opts.LivenessOnly {
+            log.Println(fi.VacanciesCount)
+        }
+    }
+    switch opts.policy(validationFrozen) {
+    case policyOff:
+        // not tracked, so never reported frozen
+        fi.UnchangedSince = time.Time{}
+    case policyFail:
+        if fi.frozen() {
+            return fmt.Errorf("Error checking %s: %w", url, errFrozen)
+        }
+    }
+    // a recount starts the warnings over, the archive ones stay till then
+    fi.Warnings = findings.merge(fi.Warnings)
+    fi.UpdatedAt = clock.Now()
+    fi.FailureSince = time.Time{}
+    fi.FailureKind = ""
+    fi.Breaker = ""
+
+    mu.Lock()
+    defer mu.Unlock()
+    if _, monitored := updaters[url]; monitored {
+        old, existed := feeds[url]
+        // options changed during the check counted by the old ones, the
+        // recount they requested is still due
+        if options[url].countingChanged(opts) {
+            fi.RecountRequested = true
+        }
+        feeds[url] = fi
+        if countDuration > 0 {
+            recordCountDuration(url, countDuration, span.traceID())
+        }
+        if !existed || fi.changed(old) {
+            publishFeed("updated", url, fi)
         }
-        log.Println(feeds[url].VacanciesCount)
     }
     return nil
 }
 
-func countVacancies(url string) (int64, error) {
-    res, err := http.Get(url)
+// countVacancies downloads and counts the feed, progress (if not nil) is
+// called with the count and downloaded bytes so far while parsing.
+func countVacancies(ctx context.Context, url string, opts FeedOptions, progress func(count, downloaded int64)) (cr countResult, err error) {
+    if opts.Index {
+        return countIndex(ctx, url, opts, progress)
+    }
+    if opts.Paged {
+        return countPages(ctx, url, opts, progress)
+    }
+    return countPage(ctx, url, opts, progress)
+}
+
+// countPage downloads and counts a single archive, NextPage is set to the
+// page it links to if the feed is paged.
+func countPage(ctx context.Context, url string, opts FeedOptions, progress func(count, downloaded int64)) (cr countResult, err error) {
+    ctx, cancel := withRequestTimeout(ctx, opts)
+    defer cancel()
+    release, err := acquireHost(ctx, url)
     if err != nil {
-        return 0, fmt.Errorf("Error fetching archive from %s: %v", url, err)
+        return cr, fmt.Errorf("Error waiting to download %s: %v", url, err)
+    }
+    defer release()
+    // the body is streamed while parsing, so download only covers getting
+    // the response
+    downloadCtx, download := startSpan(ctx, "download")
+    download.setAttr("url", url)
+    req, err := newFeedRequest(downloadCtx, url, opts)
+    if err != nil {
+        download.finish(err)
+        return cr, fmt.Errorf("Error fetching archive from %s: %v", url, err)
+    }
+    // the archive is gzip itself, it may come gzipped once more if the
+    // server compresses responses
+    if req.Header.Get("Accept-Encoding") == "" {
+        req.Header.Set("Accept-Encoding", "gzip")
+    }
+    res, err := doFeedRequest(req)
+    if err == nil && res.StatusCode >= 300 {
+        res.Body.Close()
+        err = &statusError{URL: url, Status: res.Status, Code: res.StatusCode, Archive: true, RetryAfter: retryAfter(res)}
+    }
+    if err == nil && !contentTypeAllowed(res.Header.Get("Content-Type"), cfg().ArchiveContentTypes) {
+        if err = validate(ctx, url, opts, validationContentType, &contentTypeError{URL: url, ContentType: res.Header.Get("Content-Type")}); err != nil {
+            res.Body.Close()
+        }
+    }
+    download.finish(err)
+    if err != nil {
+        return cr, fmt.Errorf("Error fetching archive from %s: %w", url, err)
     }
     defer res.Body.Close()
+    cr.ContentLanguage = res.Header.Get("Content-Language")
+    cr.ArchiveHost = res.Request.URL.Host
+    // the Link header wins over a link in the page
+    var nextLink string
+    if opts.Paged {
+        nextLink = linkNext(res.Header.Values("Link"))
+    }
 
-    uncompressedStream, err := gzip.NewReader(res.Body)
-    if err != nil {
-        return 0, fmt.Errorf("Error uncompressing response from %s: %v", url, err)
+    _, parse := startSpan(ctx, "parse")
+    resumable := newResumeReader(ctx, url, opts, res)
+    defer resumable.Close()
+    var archiveBody io.Reader = resumable
+    // the archive length is needed to extrapolate the count of a sample, a
+    // chunked one has none and the stat size is used, unless the response
+    // is encoded and is of another length
+    length := res.ContentLength
+    cr.Chunked = length < 0
+    if length < 0 && res.Header.Get("Content-Encoding") == "" {
+        length = archiveSize(ctx)
     }
-    decoder := xml.NewDecoder(uncompressedStream)
-    var count int64
-    for {
-        t, _ := decoder.Token()
-        if t == nil {
+    sampled := opts.SampleBytes > 0 && length > opts.SampleBytes
+    if sampled {
+        archiveBody = &sampleReader{r: resumable, n: opts.SampleBytes}
+    }
+    budget := opts.MaxSize
+    if opts.policy(validationSizeBudget) == policyOff {
+        budget = 0
+    }
+    body := &budgetReader{r: archiveBody, budget: budget, abort: opts.policy(validationSizeBudget) == policyFail}
+    defer func() {
+        cr.DownloadedBytes = body.N
+        cr.SizeBudgetExceeded = body.Exceeded
+        downloadedBytes.Add(body.N)
+        parse.setAttr("count", cr.VacanciesCount)
+        parse.finish(err)
+    }()
+    parse.setAttr("url", url)
+
+    // a zero-length answer is a failed fetch, not a feed with no vacancies
+    buffered := bufio.NewReader(body)
+    if _, err := buffered.Peek(1); err == io.EOF {
+        return cr, fmt.Errorf("Error fetching archive from %s: %w", url, errEmptyBody)
+    }
+    var archive io.Reader = buffered
+    contentEncoded := false
+    switch enc := strings.ToLower(res.Header.Get("Content-Encoding")); enc {
+    case "", "identity":
+    case "gzip", "x-gzip":
+        // a server may label a plain body gzip, then it's the archive as is
+        if magic, _ := buffered.Peek(2); !bytes.Equal(magic, gzipMagic) {
+            log.Printf("%s response is labeled %s but isn't gzip, read it as is", url, enc)
             break
         }
+        ce, err := gzip.NewReader(buffered)
+        if err != nil {
+            return cr, fmt.Errorf("Error decoding response from %s: %v", url, err)
+        }
+        archive, contentEncoded = ce, true
+    default:
+        return cr, fmt.Errorf("Error decoding response from %s: unsupported Content-Encoding %q", url, enc)
+    }
+    var report func(int64)
+    if progress != nil {
+        report = func(count int64) { progress(count, body.N) }
+    }
+    // a server compressing responses may send a plain feed gzipped on the
+    // fly, otherwise it's the gzip archive
+    archived, err := countArchive(archive, contentEncoded, opts, report)
+    cr.GeneratedAt, cr.AvgThroughput, cr.PeakThroughput = archived.GeneratedAt, archived.AvgThroughput, archived.PeakThroughput
+    if archived.PlainFallback {
+        log.Printf("%s archive isn't gzip, parsed as plain XML", url)
+    }
+    var ue *uncompressError
+    if errors.As(err, &ue) {
+        return cr, fmt.Errorf("Error uncompressing response from %s: %v", url, ue.Err)
+    }
+    if sampled && errors.Is(err, errSampleDone) && body.N > 0 {
+        cr.VacanciesCount = int64(float64(archived.VacanciesCount) * float64(length) / float64(body.N))
+        cr.RawCount = int64(float64(archived.RawCount) * float64(length) / float64(body.N))
+        cr.Estimated = true
+        parse.setAttr("estimated", true)
+        return cr, nil
+    }
+    // reported with the failure too, it tells a damaged archive from a
+    // malformed feed
+    cr.TrailerCorrupt = archived.TrailerCorrupt
+    if err = toleratedTrailer(url, err, opts); err != nil {
+        // don't report what was counted so far: a broken member would
+        // silently under-count the feed
+        return cr, fmt.Errorf("Error parsing %s: %w", url, err)
+    }
+    cr.VacanciesCount, cr.RawCount = archived.VacanciesCount, archived.RawCount
+    cr.ContentHash = archived.ContentHash
+    if nextLink == "" {
+        nextLink = archived.NextPage
+    }
+    if nextLink != "" {
+        next, err := res.Request.URL.Parse(nextLink)
+        if err != nil {
+            return cr, fmt.Errorf("Error parsing next page link of %s: %v", url, err)
+        }
+        cr.NextPage = next.String()
+    }
+    return cr, nil
+}
+
+// uncompressError fails an archive which isn't gzip.
+type uncompressError struct {
+    Err error
+}
+
+func (e *uncompressError) Error() string { return e.Err.Error() }
+func (e *uncompressError) Unwrap() error { return e.Err }
+
+// countArchive counts vacancies of the archive read from r. A plain feed,
+// told by the missing gzip magic, is accepted when plainAllowed. Otherwise
+// it's parsed as the last resort for a server with wrong headers, failing
+// with the uncompress error if it isn't XML either. On a read error the
+// count so far is returned with it, the hash is set on success.
+func countArchive(r io.Reader, plainAllowed bool, opts FeedOptions, progress func(int64)) (cr countResult, err error) {
+    br := bufio.NewReader(r)
+    var xmlStream io.Reader = br
+    // gzipErr is the uncompress error of an archive parsed as plain XML
+    var gzipErr error
+    if magic, _ := br.Peek(2); !bytes.Equal(magic, gzipMagic) && !plainAllowed {
+        head, _ := br.Peek(512)
+        _, gzipErr = gzip.NewReader(bytes.NewReader(head))
+        if !looksLikeXML(head) {
+            return cr, &uncompressError{Err: gzipErr}
+        }
+        cr.PlainFallback = true
+    } else if bytes.Equal(magic, gzipMagic) {
+        uncompressedStream, err := gzip.NewReader(br)
+        if err != nil {
+            return cr, &uncompressError{Err: err}
+        }
+        // Archives may consist of several concatenated gzip members, read them
+        // all as one stream.
+        uncompressedStream.Multistream(true)
+        // The feed body carries no timestamp of its own, the gzip header
+        // modification time is the only generation time we can get.
+        if mt := uncompressedStream.Header.ModTime; !mt.IsZero() && mt.Unix() > 0 {
+            cr.GeneratedAt = mt
+        }
+        xmlStream = uncompressedStream
+    }
+    hash := sha256.New()
+    throughput := &throughputReader{r: io.TeeReader(xmlStream, hash)}
+    defer func() {
+        cr.AvgThroughput, cr.PeakThroughput = throughput.average(), throughput.Peak
+    }()
+    var next *string
+    if opts.Paged {
+        next = &cr.NextPage
+    }
+    cr.VacanciesCount, cr.RawCount, err = countElements(throughput, opts.element(), opts.parent(), opts.root(), opts.attr(), cfg().MaxXMLDepth, next, progress)
+    // XML without a single vacancy element is likely an error page rather
+    // than the feed
+    if gzipErr != nil && (err != nil && !errors.Is(err, errSampleDone) || cr.RawCount == 0) {
+        return countResult{}, &uncompressError{Err: gzipErr}
+    }
+    if err == nil {
+        // gzip verifies the checksum and length at the end of the stream,
+        // make sure it's reached whatever follows the feed
+        if _, err = io.Copy(io.Discard, throughput); err != nil {
+            err = &afterRootError{Err: err}
+        }
+    }
+    var are *afterRootError
+    cr.TrailerCorrupt = errors.As(err, &are) && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrChecksum))
+    if err == nil {
+        cr.ContentHash = hex.EncodeToString(hash.Sum(nil))
+    }
+    return cr, err
+}
+
+// toleratedTrailer drops the error of an archive which trailer is corrupt
+// when the feed accepts it: a truncated upload may lose just the gzip
+// trailer, the feed itself is complete then.
+func toleratedTrailer(url string, err error, opts FeedOptions) error {
+    var are *afterRootError
+    if opts.TolerateCorruptTrailer && errors.As(err, &are) &&
+        (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrChecksum)) {
+        log.Printf("%s has a corrupt gzip trailer after a complete feed: %v", url, are.Err)
+        return nil
+    }
+    return err
+}
+
+// CountVacanciesFromReader counts vacancies of a feed archive already in
+// hand, gzipped or plain XML, without any HTTP request. It parses the same
+// way the monitored feeds are counted.
+func CountVacanciesFromReader(ctx context.Context, r io.Reader, opts FeedOptions) (int64, error) {
+    cr, err := countArchive(&ctxReader{ctx: ctx, r: r}, true, opts, nil)
+    var ue *uncompressError
+    if errors.As(err, &ue) {
+        return 0, fmt.Errorf("Error uncompressing feed: %v", ue.Err)
+    }
+    if err = toleratedTrailer("feed", err, opts); err != nil {
+        return 0, fmt.Errorf("Error parsing feed: %w", err)
+    }
+    return cr.VacanciesCount, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+const (
+    progressElements = 10000
+    progressInterval = 2 * time.Second
+)
+
+// countElements counts element in the XML stream, directly inside parent
+// unless it's empty: count are the ones passing attr, raw - all of them.
+// The root element must match root unless root is empty. If next isn't nil
+// it gets the link to the next page of the feed, the text of a <next> or
+// the href of a <link rel="next"> directly inside the root.
+// progress, if not nil, gets the count so
+// far every progressInterval. A read error is returned with the count so far,
+// as afterRootError once the root element is closed.
+func countElements(r io.Reader, element, parent, root elementName, attr attrRule, maxDepth int, next *string, progress func(int64)) (count, raw int64, err error) {
+    decoder := xml.NewDecoder(r)
+    reported := time.Now()
+    depth := 0
+    sawRoot := false
+    // nextText collects the text of a <next> element
+    var nextText *strings.Builder
+    // names of the open elements, the innermost last
+    var stack []xml.Name
+    for {
+        t, err := decoder.Token()
+        if err == io.EOF {
+            if !sawRoot && root.Local != "" {
+                return 0, 0, &wrongRootError{Expected: xml.Name(root)}
+            }
+            return count, raw, nil
+        }
+        if err != nil {
+            if sawRoot && depth == 0 {
+                return count, raw, &afterRootError{Err: err}
+            }
+            return count, raw, err
+        }
         switch se := t.(type) {
         case xml.StartElement:
-            if se.Name.Local == "vacancy" {
+            depth++
+            if depth > maxDepth {
+                return 0, 0, fmt.Errorf("feed too deeply nested (more than %d levels)", maxDepth)
+            }
+            if depth == 1 {
+                sawRoot = true
+                if root.Local != "" && !root.matches(se.Name) {
+                    return 0, 0, &wrongRootError{Root: se.Name, Expected: xml.Name(root)}
+                }
+            }
+            if next != nil && depth == 2 {
+                switch {
+                case se.Name.Local == "next":
+                    nextText = &strings.Builder{}
+                case se.Name.Local == "link" && xmlAttr(se.Attr, "rel") == "next":
+                    *next = strings.TrimSpace(xmlAttr(se.Attr, "href"))
+                }
+            }
+            inParent := parent.Local == "" || (len(stack) > 0 && parent.matches(stack[len(stack)-1]))
+            stack = append(stack, se.Name)
+            if inParent && element.matches(se.Name) {
+                raw++
+                if !attr.matches(se.Attr) {
+                    break
+                }
                 count++
+                // checking the clock on every element is too slow
+                if progress != nil && count%progressElements == 0 && time.Since(reported) >= progressInterval {
+                    progress(count)
+                    reported = time.Now()
+                }
+            }
+        case xml.CharData:
+            if nextText != nil {
+                nextText.Write(se)
+            }
+        case xml.EndElement:
+            if nextText != nil && depth == 2 {
+                *next = strings.TrimSpace(nextText.String())
+                nextText = nil
             }
+            depth--
+            stack = stack[:len(stack)-1]
         }
     }
-    return count, nil
 }
 
+// feedClient makes the requests to feeds. Its transport doesn't decompress
+// responses on its own: countVacancies asks for gzip explicitly and decodes
+// Content-Encoding itself, the archive being gzipped already.
+var (
+    feedTransport = newFeedTransport()
+    feedClient    = &http.Client{Transport: feedTransport}
+)
+
+func newFeedTransport() *http.Transport {
+    t := http.DefaultTransport.(*http.Transport).Clone()
+    t.DisableCompression = true
+    t.Proxy = feedProxy
+    return t
+}
+
+// mu guards info, updaters, registered, options, cancels and checkRequests.
+var mu sync.RWMutex
 var info = make(map[string]FeedInfo, FeedsLimit)
 var updaters = make(map[string]time.Time, FeedsLimit)
+var registered = make(map[string]time.Time, FeedsLimit)
+var options = make(map[string]FeedOptions, FeedsLimit)
+var cancels = make(map[string]context.CancelFunc, FeedsLimit)
 
-func feedInfoHandler(w http.ResponseWriter, r *http.Request) {
-    values := r.URL.Query()
-    urls, ok := values["url"]
+// checkRequest asks monitoring of a feed to check it at once, with reset
+// dropping its failure state first. done is closed after the check, or
+// right away if the feed can't be checked now.
+type checkRequest struct {
+    reset bool
+    done  chan struct{}
+}
+
+// checkRequests are sent to the monitoring of feeds.
+var checkRequests = make(map[string]chan checkRequest, FeedsLimit)
+
+// requestCheck asks for a check of a monitored feed, the returned channel
+// is closed after it. ok is false if the feed isn't monitored.
+func requestCheck(ctx context.Context, url string, reset bool) (done chan struct{}, ok bool) {
+    mu.RLock()
+    requests, ok := checkRequests[url]
+    mu.RUnlock()
     if !ok {
-        w.WriteHeader(http.StatusBadRequest)
+        return nil, false
+    }
+    done = make(chan struct{})
+    select {
+    case requests <- checkRequest{reset: reset, done: done}:
+    case <-ctx.Done():
+    }
+    return done, true
+}
+
+var (
+    errFeedNotAlive = errors.New("feed isn't alive")
+    errFeedsLimit   = errors.New("feeds limit is exhausted")
+)
+
+type registration struct {
+    done chan struct{}
+    err  error
+}
+
+// registering holds registrations in progress, guarded by mu.
+var registering = make(map[string]*registration)
+
+// registerFeed starts monitoring of url unless it's monitored already.
+// Concurrent registrations of one url join the first one and share its
+// outcome, so exactly one poll goroutine is started per feed.
+func registerFeed(ctx context.Context, url string, opts FeedOptions) error {
+    mu.Lock()
+    if _, ok := updaters[url]; ok {
+        mu.Unlock()
+        return nil
+    }
+    if reg, ok := registering[url]; ok {
+        mu.Unlock()
+        <-reg.done
+        return reg.err
+    }
+    reg := &registration{done: make(chan struct{})}
+    registering[url] = reg
+    mu.Unlock()
+
+    reg.err = startMonitoring(ctx, url, opts)
+
+    mu.Lock()
+    delete(registering, url)
+    mu.Unlock()
+    close(reg.done)
+    return reg.err
+}
+
+func startMonitoring(ctx context.Context, url string, opts FeedOptions) error {
+    if !feedIsAlive(ctx, url, opts) {
+        return errFeedNotAlive
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+    if len(updaters) >= FeedsLimit {
+        return errFeedsLimit
+    }
+    monitorCtx, cancel := context.WithCancel(context.Background())
+    updaters[url] = clock.Now()
+    registered[url] = updaters[url]
+    options[url] = opts
+    cancels[url] = cancel
+    requests := make(chan checkRequest)
+    checkRequests[url] = requests
+    if len(opts.Headers) > 0 {
+        log.Printf("start monitoring %s with headers %v", url, opts.redactedHeaders())
+    }
+    if opts.Proxy != "" {
+        u, _ := parseProxyURL(opts.Proxy)
+        log.Printf("start monitoring %s through proxy %s", url, u.Redacted())
+    }
+    // the first check continues the trace of the registering request
+    go monitorFeed(monitorCtx, continueTrace(monitorCtx, ctx), url, requests)
+    return nil
+}
+
+// forgetFeed stops monitoring of url, mu must be held.
+func forgetFeed(url string) {
+    if cancel, ok := cancels[url]; ok {
+        cancel()
+    }
+    delete(updaters, url)
+    delete(info, url)
+    delete(registered, url)
+    delete(options, url)
+    delete(cancels, url)
+    delete(checkRequests, url)
+    delete(failureHistory, url)
+    delete(countDurations, url)
+    delete(checkTallies, url)
+    publish(feedEvent{Type: "removed", URL: url})
+}
+
+const maxBackoff = 30 * time.Minute
+
+// nextBackoff doubles the delay between checks of a feed failing
+// transiently, starting from the poll interval.
+func nextBackoff(backoff, interval time.Duration) time.Duration {
+    if backoff == 0 {
+        return interval
+    }
+    backoff *= 2
+    if backoff > maxBackoff {
+        backoff = max(maxBackoff, interval)
+    }
+    return backoff
+}
+
+// forgetIfIdle stops monitoring of url if it's not requested for
+// IdleTimeout, mu must be held.
+func forgetIfIdle(ctx context.Context, url string) {
+    if options[url].Pinned {
         return
     }
-    url := urls[0]
+    idleTimeout := cfg().IdleTimeout.Duration + timeoutJitter(jitterFraction(url))
+    idle := since(updaters[url]) > idleTimeout
+    if idle && ctx.Err() == nil {
+        log.Printf("info about %s is not requested for %v - cancel monitoring", url, idleTimeout)
+        forgetFeed(url)
+    }
+}
+
+// monitorFeed keeps info about url up to date until it isn't requested for
+// IdleTimeout or ctx is cancelled. The first check runs with firstCtx.
+// A requested check runs without waiting, a reset clears the failure state
+// before it.
+func monitorFeed(ctx, firstCtx context.Context, url string, requests <-chan checkRequest) {
+    activePolls.Add(1)
+    defer activePolls.Add(-1)
+    interval := cfg().PollInterval.Duration
+    ticker := clock.NewTicker(interval)
+    defer ticker.Stop()
+    checkCtx := firstCtx
+    failedStatus := ""
+    // deadSince is the start of the current run of dead failures, backoff
+    // the current delay after transient ones.
+    var deadSince time.Time
+    var backoff time.Duration
+    // retryAt is the Retry-After of the last failure, requested checks
+    // don't go to the server before it
+    var retryAt time.Time
+    // checkedAt is the start of the last check, failed - whether it failed
+    var checkedAt time.Time
+    failed := false
+    b := breaker{state: breakerClosed}
+    // requestDone is closed after the requested check
+    var requestDone chan struct{}
+    applyReset := func() {
+        b = breaker{state: breakerClosed}
+        backoff, deadSince, failedStatus, retryAt = 0, time.Time{}, "", time.Time{}
+        ticker.Reset(interval)
+        mu.Lock()
+        if feed, ok := info[url]; ok {
+            feed.FailureSince, feed.FailureKind, feed.Breaker, feed.RetryAt = time.Time{}, "", "", time.Time{}
+            info[url] = feed
+        }
+        recordRecovery(url, clock.Now())
+        mu.Unlock()
+        log.Printf("%s failure state is reset", url)
+    }
+    for {
+        if d := cfg().PollInterval.Duration; d != interval {
+            interval = d
+            ticker.Reset(interval)
+        }
+        if paused.Load() {
+            // neither checks nor evictions: nothing is lost while paused
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C():
+                continue
+            case req := <-requests:
+                // nothing is checked while paused
+                close(req.done)
+                continue
+            }
+        }
+        if !b.allow(clock.Now()) {
+            // no checks while the breaker is open, but an unrequested
+            // feed is still forgotten
+            mu.Lock()
+            forgetIfIdle(ctx, url)
+            mu.Unlock()
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C():
+                continue
+            case req := <-requests:
+                if !req.reset {
+                    close(req.done)
+                    continue
+                }
+                applyReset()
+                requestDone = req.done
+            }
+        }
+        mu.RLock()
+        fi, counted := info[url]
+        mu.RUnlock()
+        if statInterval := cfg().StatInterval.Duration; counted && requestDone == nil && !failed &&
+            !fi.RecountRequested && since(checkedAt) < statInterval {
+            // the stat isn't due yet, the poll only forgets an idle feed
+            mu.Lock()
+            forgetIfIdle(ctx, url)
+            mu.Unlock()
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C():
+                continue
+            case req := <-requests:
+                if req.reset {
+                    applyReset()
+                }
+                requestDone = req.done
+            }
+        }
+        admitCtx := ctx
+        if !counted {
+            admitCtx, checkCtx = withFirstCount(ctx), withFirstCount(checkCtx)
+        }
+        release, err := admitCheck(admitCtx)
+        if err != nil {
+            return
+        }
+        checkedAt = clock.Now()
+        err = updateInfoIfNeed(checkCtx, url, info)
+        failed = err != nil
+        totalChecks.Add(1)
+        if failed {
+            failedChecks.Add(1)
+        }
+        release()
+        checkCtx = ctx
+        wasOpen := b.state
+        b.record(err, clock.Now())
+        if b.state != wasOpen {
+            log.Printf("%s circuit breaker is %s", url, b.state)
+        }
+        kind := classifyFailure(err)
+        if (err == nil || kind != failureTransient) && backoff > 0 {
+            backoff = 0
+            ticker.Reset(interval)
+        }
+        if err == nil || (kind != failureDead && kind != failureArchiveUnavailable) {
+            deadSince = time.Time{}
+        }
+        retryAt = retryAfterOf(err)
+        if err != nil {
+            log.Println(err)
+            mu.Lock()
+            recordFailure(url, kind, err, clock.Now())
+            recordCheck(url, false, clock.Now())
+            feed, ok := info[url]
+            if ok {
+                if feed.FailureSince.IsZero() {
+                    feed.FailureSince = clock.Now()
+                }
+                feed.FailureKind = kind
+                feed.Breaker = b.state
+                feed.RetryAt = retryAt
+                feed.LastError, feed.LastErrorAt = redactError(err), clock.Now()
+                info[url] = feed
+            }
+            // failing turns into failed with time, report both
+            if ok && feed.status() != failedStatus {
+                failedStatus = feed.status()
+                publishFeed(failedStatus, url, feed)
+            }
+            switch kind {
+            case failureDead, failureArchiveUnavailable:
+                if deadSince.IsZero() {
+                    deadSince = clock.Now()
+                }
+                deadTimeout := cfg().DeadFeedTimeout.Duration
+                if kind == failureArchiveUnavailable {
+                    deadTimeout = cfg().ArchiveUnavailableTimeout.Duration
+                }
+                if deadTimeout > 0 && since(deadSince) >= deadTimeout && ctx.Err() == nil {
+                    log.Printf("%s is gone for %v (%s) - cancel monitoring", url, deadTimeout, kind)
+                    forgetFeed(url)
+                }
+            case failureTransient:
+                backoff = nextBackoff(backoff, interval)
+                // the server knows best when it can take the next request,
+                // the check after it is back to the backoff or interval
+                if wait := retryAt.Sub(clock.Now()); wait > backoff {
+                    log.Printf("%s asked to retry after %v", url, wait.Round(time.Second))
+                    ticker.Reset(wait)
+                } else {
+                    ticker.Reset(backoff)
+                }
+            }
+            mu.Unlock()
+        } else {
+            failedStatus = ""
+            mu.Lock()
+            recordRecovery(url, clock.Now())
+            recordCheck(url, true, clock.Now())
+            if feed, ok := info[url]; ok && !feed.RetryAt.IsZero() {
+                feed.RetryAt = time.Time{}
+                info[url] = feed
+            }
+            forgetIfIdle(ctx, url)
+            mu.Unlock()
+        }
+        if requestDone != nil {
+            close(requestDone)
+            requestDone = nil
+        }
+
+    wait:
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C():
+            case req := <-requests:
+                if !req.reset && clock.Now().Before(retryAt) {
+                    // answered with the info at hand rather than
+                    // requesting a server which asked to wait
+                    close(req.done)
+                    continue wait
+                }
+                if req.reset {
+                    applyReset()
+                }
+                requestDone = req.done
+            }
+            break
+        }
+    }
+}
+
+// requestedFeed registers the feed of a /feedinfo or /count request if
+// needed and returns its info, counted is false until the first count
+// completes. ok is false if the request was answered already.
+func requestedFeed(w http.ResponseWriter, r *http.Request) (url string, feed FeedInfo, counted bool, registeredAt time.Time, ok bool) {
+    values := r.URL.Query()
+    urls, found := values["url"]
+    if !found {
+        writeError(w, r, http.StatusBadRequest, apiError{
+            Code: "missing_url", Message: "url parameter is required", Required: feedInfoRequired})
+        return
+    }
+    url = urls[0]
     if len(url) == 0 {
-        log.Printf("Error getting feed %s size - refuse serving\n", url)
-        w.WriteHeader(http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, apiError{
+            Code: "empty_url", Message: "url parameter is empty", Required: feedInfoRequired})
+        return
+    }
+    url, err := canonicalURL(url)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, apiError{Code: "invalid_url", Message: err.Error()})
         return
     }
 
-    _, ok = updaters[url]
-    if !ok {
-
-        if !feedIsAlive(url) {
+    mu.RLock()
+    _, monitored := updaters[url]
+    mu.RUnlock()
+    // a HEAD probe doesn't start monitoring, it needs the read key only
+    probe := r.Method == http.MethodHead
+    if (monitored || probe) && !authorizedRead(r) || !monitored && !probe && !authorized(r) {
+        unauthorized(w)
+        return
+    }
+    if !monitored && probe {
+        w.WriteHeader(http.StatusNotFound)
+        return
+    }
+    if !monitored {
+        if !cfg().hostAllowed(url) {
+            log.Printf("%s isn't on the allowed hosts list - refuse monitoring", url)
+            w.WriteHeader(http.StatusForbidden)
+            w.Write([]byte("feed host is not allowed"))
+            return
+        }
+        opts, err := parseFeedOptions(values)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, apiError{Code: "invalid_options", Message: err.Error()})
+            return
+        }
+        switch err := registerFeed(traceContextFromRequest(r), url, opts); err {
+        case nil:
+        case errFeedNotAlive:
             log.Printf("%s isn't alive - return 404", url)
             w.WriteHeader(http.StatusNotFound)
             return
-        }
-
-        if len(updaters) >= FeedsLimit {
+        case errFeedsLimit:
             w.WriteHeader(http.StatusPaymentRequired)
             w.Write([]byte(fmt.Sprintf("Feeds limit (%d) is exhausted:\n", FeedsLimit)))
+            mu.RLock()
             for url, _ := range updaters {
                 w.Write([]byte(fmt.Sprintf("%s\n", url)))
             }
+            mu.RUnlock()
             return
         }
-        go func(c <-chan time.Time, url string) {
-            for ; ; <-c {
-                err := updateInfoIfNeed(url, info)
-                if err != nil {
-                    log.Println(err)
-                    feed, ok := info[url]
-                    if ok && feed.FailureSince.IsZero() {
-                        feed.FailureSince = time.Now()
-                    }
-                    continue
-                }
+    }
+    feed, counted, registeredAt = touchFeed(url)
+    return url, feed, counted, registeredAt, true
+}
 
-                if time.Since(updaters[url]) > (6 * time.Hour) {
-                    log.Printf("info about %s is not requested for 6 hours - cancel monitoring", url)
-                    delete(updaters, url)
-                    delete(info, url)
-                    return
-                }
-            }
-        }(time.Tick(time.Minute), url)
+// touchFeed marks a monitored feed requested and returns its info.
+func touchFeed(url string) (feed FeedInfo, counted bool, registeredAt time.Time) {
+    mu.Lock()
+    defer mu.Unlock()
+    updaters[url] = clock.Now()
+    feed, counted = info[url]
+    return feed, counted, registered[url]
+}
+
+// failedMessage tells why the info about a failed feed isn't served.
+func failedMessage(url string) string {
+    failureTimeout := cfg().FailureTimeout.Duration
+    log.Printf("info about %s could not be updated for more than %v - return error", url, failureTimeout)
+    return fmt.Sprintf("information could not be obtained for more than %v", failureTimeout)
+}
+
+// lastGoodMessage tells how long a failed feed served its last good info
+// has been failing.
+func lastGoodMessage(url string, feed FeedInfo) string {
+    failingFor := since(feed.FailureSince).Round(time.Second)
+    log.Printf("info about %s could not be updated for %v - return the last good one", url, failingFor)
+    return fmt.Sprintf("information could not be updated for %v, the last good one is shown", failingFor)
+}
+
+// writeHead answers a HEAD /feedinfo with the code and the content type of
+// the GET, its body isn't built.
+func writeHead(w http.ResponseWriter, r *http.Request, code int) {
+    if wantsJSON(r) {
+        w.Header().Set("Content-Type", "application/json")
+    } else {
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
     }
-    updaters[url] = time.Now()
+    w.WriteHeader(code)
+}
 
-    feed, ok := info[url]
+func feedInfoHandler(w http.ResponseWriter, r *http.Request) {
+    url, feed, counted, registeredAt, ok := requestedFeed(w, r)
     if !ok {
-        w.WriteHeader(http.StatusAccepted)
         return
     }
-    if !feed.FailureSince.IsZero() && time.Since(feed.FailureSince) > 6*time.Hour {
-        log.Printf("info about %s could not be updated for more than 6 hours - return error", url)
+    // info older than maxAge is refreshed, or served marked stale
+    stale := false
+    if v := r.URL.Query().Get("maxAge"); v != "" {
+        maxAge, err := parseMaxAge(v)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, apiError{Code: "invalid_max_age", Message: err.Error()})
+            return
+        }
+        if counted && since(feed.UpdatedAt) > maxAge {
+            if fresh := refreshFeed(r.Context(), url); !fresh.UpdatedAt.IsZero() {
+                feed = fresh
+            }
+        }
+        stale = counted && since(feed.UpdatedAt) > maxAge
+    }
+    if counted {
+        setDataAge(w, since(feed.UpdatedAt))
+        setInfoETag(w, feed)
+    }
+    if !counted {
+        // known but not counted yet
+        status, code := "counting", http.StatusAccepted
+        if cfg().PendingStatusOK {
+            status, code = "pending", http.StatusOK
+        }
+        setQueueDepth(w)
+        if r.Method == http.MethodHead {
+            writeHead(w, r, code)
+            return
+        }
+        if wantsJSON(r) {
+            writeJSON(w, code, feedInfoResponse{
+                URL:          url,
+                Status:       status,
+                RegisteredAt: optionalTime(registeredAt),
+            })
+            return
+        }
+        w.WriteHeader(code)
+        w.Write([]byte(fmt.Sprintf("counting vacancies, registered at %s\n", registeredAt.UTC().Format(time.RFC3339))))
+        return
+    }
+    var failures []failureEpisode
+    if r.URL.Query().Get("failures") == "true" {
+        mu.RLock()
+        failures = failureEpisodes(url)
+        mu.RUnlock()
+    }
+    failed := feed.status() == "failed"
+    if r.Method == http.MethodHead {
+        if failed && !cfg().ServeLastGood {
+            writeHead(w, r, http.StatusExpectationFailed)
+            return
+        }
+        writeHead(w, r, http.StatusOK)
+        return
+    }
+    if failed && !cfg().ServeLastGood {
+        msg := failedMessage(url)
+        if wantsJSON(r) {
+            resp := newFeedInfoResponse(url, feed)
+            resp.Error = msg
+            resp.Failures = failures
+            resp.Stale = stale
+            writeJSON(w, http.StatusExpectationFailed, resp)
+            return
+        }
         w.WriteHeader(http.StatusExpectationFailed)
-        w.Write([]byte("information could not be obtained for more than 6 hours"))
+        w.Write([]byte(msg))
+        writeFailures(w, failures)
+        return
+    }
+    if wantsJSON(r) {
+        resp := newFeedInfoResponse(url, feed)
+        resp.Failures = failures
+        resp.Stale = stale
+        if failed {
+            resp.Error = lastGoodMessage(url, feed)
+            resp.FailingFor = int64(since(feed.FailureSince).Seconds())
+        }
+        writeJSON(w, http.StatusOK, resp)
+        return
+    }
+    body := fmt.Sprintf("%s, vacanciesCount: %v", feed.Stat, feed.VacanciesCount)
+    if feed.LivenessOnly {
+        body = fmt.Sprintf("%s, livenessOnly: true", feed.Stat)
+    }
+    if failed {
+        body = fmt.Sprintf("FAILED: %s\n%s", lastGoodMessage(url, feed), body)
+    }
+    if feed.Estimated {
+        body += ", estimated: true"
+    }
+    if !feed.GeneratedAt.IsZero() {
+        body += fmt.Sprintf(", generatedAt: %s", feed.GeneratedAt.UTC().Format(time.RFC3339))
     }
-    w.Write([]byte(fmt.Sprintf("%s, vacanciesCount: %v", feed.Stat, feed.VacanciesCount)))
+    if feed.Refreshing {
+        body += ", refreshing: true"
+        if feed.PartialCount > 0 {
+            body += fmt.Sprintf(", partialCount: %d", feed.PartialCount)
+        }
+    }
+    if paused.Load() {
+        body += ", paused: true"
+    }
+    if stale {
+        body += ", stale: true"
+    }
+    w.Write([]byte(body))
+    writeFailures(w, failures)
 }
 
+var (
+    listenAddr = flag.String("listen", ":8080", "address to serve on")
+    enableH2C  = flag.Bool("h2c", false, "accept cleartext HTTP/2 (h2c), e.g. behind a proxy")
+)
+
 func main() {
-    http.HandleFunc("/feedinfo", feedInfoHandler)
-    hostPort := ":8080"
-    log.Printf("Listening on %s\n", hostPort)
-    http.ListenAndServe(hostPort, nil)
+    flag.Parse()
+    c, err := loadConfig()
+    if err != nil {
+        log.Fatalf("Error loading config: %v", err)
+    }
+    config.Store(c)
+    go reloadConfigOnSIGHUP()
+
+    if err := compileSizePattern(*sizePattern); err != nil {
+        log.Fatal(err)
+    }
+    log.Printf("Stat size pattern: %s\n", sizeRe)
+
+    if *proxyFlag != "" {
+        if defaultProxy, err = parseProxyURL(*proxyFlag); err != nil {
+            log.Fatal(err)
+        }
+        log.Printf("Requesting feeds through %s\n", defaultProxy.Redacted())
+    }
+
+    feedTransport.MaxConnsPerHost = *maxConnsPerHost
+    feedTransport.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+    log.Printf("Feed hosts limits: %d connections, %d idle connections, %d downloads at once\n",
+        feedTransport.MaxConnsPerHost, feedTransport.MaxIdleConnsPerHost, *maxDownloadsPerHost)
+
+    if *maxAuxMemory > 0 {
+        go capAuxMemory()
+    }
+
+    preregistered := make(map[string]bool)
+    if *feedsPath != "" {
+        feeds, err := loadPreregisteredFeeds(*feedsPath)
+        if err != nil {
+            log.Fatal(err)
+        }
+        for _, f := range feeds {
+            preregistered[f.URL] = true
+            if f.Pinned {
+                startupPinned = append(startupPinned, f.URL)
+            }
+        }
+        go preregisterFeeds(feeds)
+    }
+    if *statePath != "" {
+        st, err := loadState(*statePath)
+        if err != nil {
+            log.Fatal(err)
+        }
+        // the -feeds file options win over the saved ones
+        var feeds []savedFeed
+        for _, f := range st.Feeds {
+            if !preregistered[f.URL] {
+                feeds = append(feeds, f)
+            }
+        }
+        if len(feeds) > 0 {
+            log.Printf("Restoring %d feeds saved to %s at %s\n", len(feeds), *statePath, st.SavedAt.Format(time.RFC3339))
+        }
+        restorePause(st)
+        go restoreFeeds(feeds)
+        go saveStatePeriodically(*statePath)
+    }
+
+    if *otelEndpoint != "" {
+        tracer = newOTLPExporter(*otelEndpoint)
+        log.Printf("Exporting traces to %s\n", tracer.url)
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/feedinfo", feedInfoHandler)
+    mux.HandleFunc("/count", countHandler)
+    mux.HandleFunc("/compare", compareHandler)
+    mux.HandleFunc("/export", withStreamDeadline(requireReadKey(exportHandler)))
+    mux.HandleFunc("/readyz", readyzHandler)
+    mux.HandleFunc("/feeds", withStreamDeadline(feedsHandler))
+    mux.HandleFunc("/feeds/touch", requireReadKey(touchFeedsHandler))
+    mux.HandleFunc("/stats", requireReadKey(statsHandler))
+    mux.HandleFunc("/events", requireReadKey(eventsHandler))
+    mux.HandleFunc("/metrics", requireReadKey(metricsHandler))
+    mux.HandleFunc("/admin/pause", requireAdminKey(pauseHandler(true)))
+    mux.HandleFunc("/admin/resume", requireAdminKey(pauseHandler(false)))
+    mux.HandleFunc("/admin/pin", requireAdminKey(pinHandler))
+    mux.HandleFunc("/admin/feeds/reset", requireAdminKey(resetHandler))
+    mux.HandleFunc("/admin/feeds/options", requireAdminKey(optionsHandler))
+    mux.HandleFunc("/admin/selftest", requireAdminKey(selftestHandler))
+    mux.HandleFunc("/debug/feeds/", requireAdminKey(debugFeedHandler))
+
+    var handler http.Handler = mux
+    if *pathPrefix != "" {
+        handler = withPathPrefix(mux, *pathPrefix, splitList(*unprefixed))
+        log.Printf("Serving under %s\n", *pathPrefix)
+    }
+    server := &http.Server{
+        Addr:         *listenAddr,
+        Handler:      handler,
+        WriteTimeout: *writeTimeout,
+    }
+    if *enableH2C {
+        // HTTP/2 over TLS is negotiated automatically; cleartext HTTP/2
+        // has to be asked for explicitly.
+        server.Protocols = new(http.Protocols)
+        server.Protocols.SetHTTP1(true)
+        server.Protocols.SetUnencryptedHTTP2(true)
+    }
+    log.Printf("Listening on %s (h2c: %v)\n", server.Addr, *enableH2C)
+    log.Fatal(server.ListenAndServe())
 
     // url := "http://hh.ru/yandexvacancies.mvc.gz"
 }
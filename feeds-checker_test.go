@@ -0,0 +1,565 @@
+package main
+
+import (
+    "compress/gzip"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "reflect"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+)
+
+// useFeedTransport sends the requests to feeds through tr for the test.
+func useFeedTransport(t *testing.T, tr *http.Transport) {
+    prevTransport, prevClient := feedTransport, feedClient
+    feedTransport, feedClient = tr, &http.Client{Transport: tr}
+    t.Cleanup(func() { feedTransport, feedClient = prevTransport, prevClient })
+}
+
+func TestFeedRequestsUseHTTP2(t *testing.T) {
+    var protoMu sync.Mutex
+    protos := make(map[string]int)
+    archive := gzipped(t, vacanciesXML(3))
+    srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        protoMu.Lock()
+        protos[r.URL.RawQuery] = r.ProtoMajor
+        protoMu.Unlock()
+        feedHandler(archive)(w, r)
+    }))
+    srv.EnableHTTP2 = true
+    srv.StartTLS()
+    defer srv.Close()
+    roots := x509.NewCertPool()
+    roots.AddCert(srv.Certificate())
+    tr := newFeedTransport()
+    tr.TLSClientConfig = &tls.Config{RootCAs: roots}
+    useFeedTransport(t, tr)
+
+    url := srv.URL + "/feed.xml.gz"
+    size, _, _, err := getFeedSize(context.Background(), url, FeedOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if size.Bytes != int64(len(archive)) {
+        t.Errorf("stat size is %d, expected %d", size.Bytes, len(archive))
+    }
+    cr, err := countVacancies(context.Background(), url, FeedOptions{}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cr.VacanciesCount != 3 {
+        t.Errorf("counted %d vacancies, expected 3", cr.VacanciesCount)
+    }
+
+    protoMu.Lock()
+    defer protoMu.Unlock()
+    for query, name := range map[string]string{"stat": "stat", "": "archive"} {
+        if protos[query] != 2 {
+            t.Errorf("%s was fetched over HTTP/%d, expected HTTP/2", name, protos[query])
+        }
+    }
+}
+
+// nestedXML is a vacancy wrapped in elements up to depth levels.
+func nestedXML(depth int) string {
+    open := strings.Repeat("<level>", depth-1)
+    closing := strings.Repeat("</level>", depth-1)
+    return open + "<vacancy/>" + closing
+}
+
+func TestCountElementsMaxDepth(t *testing.T) {
+    maxDepth := cfg().MaxXMLDepth
+    vacancy := elementName{Local: "vacancy"}
+    count, _, err := countElements(strings.NewReader(nestedXML(maxDepth)), vacancy, elementName{}, elementName{}, attrRule{}, maxDepth, nil, nil)
+    if err != nil || count != 1 {
+        t.Errorf("at the depth limit counted %d, %v, expected 1", count, err)
+    }
+    for _, depth := range []int{maxDepth + 1, 100000} {
+        count, _, err := countElements(strings.NewReader(nestedXML(depth)), vacancy, elementName{}, elementName{}, attrRule{}, maxDepth, nil, nil)
+        if err == nil || !strings.Contains(err.Error(), "too deeply nested") {
+            t.Errorf("at depth %d got %v, expected the depth failure", depth, err)
+        }
+        if count != 0 {
+            t.Errorf("at depth %d counted %d, expected none", depth, count)
+        }
+    }
+}
+
+func TestConcurrentRegistrationsStartOnePoller(t *testing.T) {
+    forgetFeedsAfter(t)
+    var stats atomic.Int64
+    archive := gzipped(t, vacanciesXML(5))
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.RawQuery == "stat" {
+            stats.Add(1)
+        }
+        feedHandler(archive)(w, r)
+    }))
+    defer srv.Close()
+
+    url := srv.URL + "/feed.xml.gz"
+    const clients = 20
+    var wg sync.WaitGroup
+    codes := make(chan int, clients)
+    for i := 0; i < clients; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            w := httptest.NewRecorder()
+            feedInfoHandler(w, httptest.NewRequest(http.MethodGet, "/feedinfo?url="+url, nil))
+            codes <- w.Code
+        }()
+    }
+    wg.Wait()
+    close(codes)
+    for code := range codes {
+        if code != http.StatusAccepted && code != http.StatusOK {
+            t.Errorf("registration answered %d", code)
+        }
+    }
+    waitCounted(t, url)
+
+    mu.RLock()
+    monitored := len(updaters)
+    mu.RUnlock()
+    if monitored != 1 {
+        t.Errorf("%d feeds monitored, expected 1", monitored)
+    }
+    if n := activePolls.Load(); n != 1 {
+        t.Errorf("%d poll loops running, expected 1", n)
+    }
+    // the liveness check of the registration and the first check of the
+    // only poller
+    if n := stats.Load(); n != 2 {
+        t.Errorf("stat was fetched %d times, expected 2", n)
+    }
+}
+
+func TestCountVacanciesMultiMemberGzip(t *testing.T) {
+    // a streamed feed compressed in two members, split between vacancies
+    first := `<?xml version="1.0" encoding="UTF-8"?>` + "\n<vacancies>\n" + strings.Repeat(`<vacancy id="1"/>`, 3)
+    second := strings.Repeat(`<vacancy id="2"/>`, 4) + "</vacancies>\n"
+    archive := append(gzipped(t, first), gzipped(t, second)...)
+    srv := httptest.NewServer(feedHandler(archive))
+    defer srv.Close()
+
+    cr, err := countVacancies(context.Background(), srv.URL+"/feed.xml.gz", FeedOptions{}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cr.VacanciesCount != 3+4 {
+        t.Errorf("counted %d vacancies, expected %d of both members", cr.VacanciesCount, 3+4)
+    }
+}
+
+func TestClassifyFailure(t *testing.T) {
+    const feedURL = "http://example.com/feed.xml.gz"
+    status := func(code int, archive bool) error {
+        return fmt.Errorf("Error fetching archive from %s: %w", feedURL,
+            &statusError{URL: feedURL, Status: http.StatusText(code), Code: code, Archive: archive})
+    }
+    _, _, parseErr := countElements(strings.NewReader("<vacancies><vacancy>"), elementName{Local: "vacancy"}, elementName{}, elementName{}, attrRule{}, 10, nil, nil)
+    tests := []struct {
+        name     string
+        err      error
+        expected failureKind
+    }{
+        {"stat 404", status(http.StatusNotFound, false), failureDead},
+        {"stat 410", status(http.StatusGone, false), failureDead},
+        {"archive 404", status(http.StatusNotFound, true), failureArchiveUnavailable},
+        {"archive 410", status(http.StatusGone, true), failureArchiveUnavailable},
+        {"stat 403", status(http.StatusForbidden, false), failureOther},
+        {"stat 400", status(http.StatusBadRequest, false), failureOther},
+        {"stat 429", status(http.StatusTooManyRequests, false), failureTransient},
+        {"stat 500", status(http.StatusInternalServerError, false), failureTransient},
+        {"archive 503", status(http.StatusServiceUnavailable, true), failureTransient},
+        {"request timeout", fmt.Errorf("Error fetching stat from %s: %w", feedURL, &url.Error{Op: "Get", URL: feedURL, Err: context.DeadlineExceeded}), failureTransient},
+        {"parse failure", fmt.Errorf("Error parsing %s: %w", feedURL, parseErr), failureOther},
+        {"checksum", fmt.Errorf("Error parsing %s: %w", feedURL, &afterRootError{Err: gzip.ErrChecksum}), failureCorrupt},
+        {"wrong root", &wrongRootError{Root: xml.Name{Local: "html"}, Expected: xml.Name{Local: "vacancies"}}, failureWrongDocument},
+        {"empty body", fmt.Errorf("Error fetching archive from %s: %w", feedURL, errEmptyBody), failureEmptyBody},
+        {"other", errors.New("something broke"), failureOther},
+    }
+    if parseErr == nil {
+        t.Fatal("an unclosed feed parsed")
+    }
+    for _, test := range tests {
+        if kind := classifyFailure(test.err); kind != test.expected {
+            t.Errorf("%s (%v) is classified %q, expected %q", test.name, test.err, kind, test.expected)
+        }
+    }
+}
+
+func TestCountVacanciesNegotiatesGzip(t *testing.T) {
+    archive := gzipped(t, vacanciesXML(4))
+    var encodedMu sync.Mutex
+    var encoded []bool
+    // gzips the response on the fly only if asked to, like a compressing
+    // proxy in front of the archive
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        gzipAccepted := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+        encodedMu.Lock()
+        encoded = append(encoded, gzipAccepted)
+        encodedMu.Unlock()
+        w.Header().Set("Content-Type", "application/gzip")
+        if gzipAccepted {
+            w.Header().Set("Content-Encoding", "gzip")
+            w.Write(gzipped(t, string(archive)))
+            return
+        }
+        w.Write(archive)
+    }))
+    defer srv.Close()
+
+    tests := []struct {
+        name    string
+        opts    FeedOptions
+        encoded bool
+    }{
+        {"default", FeedOptions{}, true},
+        {"identity", FeedOptions{Headers: map[string]string{"Accept-Encoding": "identity"}}, false},
+    }
+    for i, test := range tests {
+        cr, err := countVacancies(context.Background(), srv.URL+"/feed.xml.gz", test.opts, nil)
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if cr.VacanciesCount != 4 {
+            t.Errorf("%s: counted %d vacancies, expected 4", test.name, cr.VacanciesCount)
+        }
+        encodedMu.Lock()
+        if encoded[i] != test.encoded {
+            t.Errorf("%s: response gzipped is %v, expected %v", test.name, encoded[i], test.encoded)
+        }
+        encodedMu.Unlock()
+    }
+}
+
+func TestRegistrationStripsAndRejects(t *testing.T) {
+    forgetFeedsAfter(t)
+    srv := httptest.NewServer(feedHandler(gzipped(t, vacanciesXML(1))))
+    defer srv.Close()
+    feedURL := srv.URL + "/feed.xml.gz"
+    register := func(values url.Values) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        feedInfoHandler(w, httptest.NewRequest(http.MethodGet, "/feedinfo?"+values.Encode(), nil))
+        return w
+    }
+
+    // a pasted stat url and transport headers are stripped
+    w := register(url.Values{
+        "url":    {feedURL + "?stat"},
+        "header": {"Connection: close", "Host: elsewhere.example.com", "Keep-Alive: 300", "Te: trailers", "X-Partner: 42"},
+    })
+    if w.Code != http.StatusAccepted {
+        t.Fatalf("registration answered %d: %s", w.Code, w.Body)
+    }
+    mu.RLock()
+    opts, monitored := options[feedURL]
+    mu.RUnlock()
+    if !monitored {
+        t.Fatalf("%s isn't monitored after registering its stat url", feedURL)
+    }
+    if expected := map[string]string{"X-Partner": "42"}; !reflect.DeepEqual(opts.Headers, expected) {
+        t.Errorf("feed headers are %v, expected %v", opts.Headers, expected)
+    }
+
+    for _, values := range []url.Values{
+        {"url": {feedURL + "?stat=1"}},
+        {"url": {feedURL + "?stat&page=2"}},
+        {"url": {srv.URL + "/other.xml.gz"}, "header": {"Bad Name: x"}},
+        {"url": {srv.URL + "/other.xml.gz"}, "header": {"X-Partner: 4\r\n2"}},
+        {"url": {srv.URL + "/other.xml.gz"}, "header": {"X-Partner"}},
+    } {
+        if w := register(values); w.Code != http.StatusBadRequest {
+            t.Errorf("%v answered %d, expected 400", values, w.Code)
+        }
+    }
+    mu.RLock()
+    defer mu.RUnlock()
+    if len(updaters) != 1 {
+        t.Errorf("%d feeds monitored, expected only %s", len(updaters), feedURL)
+    }
+}
+
+func TestRequestedFeedErrorsAreJSON(t *testing.T) {
+    tests := []struct {
+        query, code string
+    }{
+        {"", "missing_url"},
+        {"url=", "empty_url"},
+        {"url=ftp://example.com/feed", "invalid_url"},
+        {"url=http://example.com/feed&maxSize=-1", "invalid_options"},
+    }
+    for _, test := range tests {
+        r := httptest.NewRequest(http.MethodGet, "/feedinfo?"+test.query, nil)
+        r.Header.Set("Accept", "application/json")
+        w := httptest.NewRecorder()
+        feedInfoHandler(w, r)
+        var body map[string]apiError
+        if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+            t.Errorf("%q answered %q: %v", test.query, w.Body, err)
+            continue
+        }
+        if w.Code != http.StatusBadRequest || body["error"].Code != test.code {
+            t.Errorf("%q answered %d with code %q, expected 400 with %q", test.query, w.Code, body["error"].Code, test.code)
+        }
+    }
+}
+
+// monitorForTest makes url monitored with opts without a poll loop, its
+// checks are run by the test.
+func monitorForTest(t *testing.T, url string, opts FeedOptions) {
+    mu.Lock()
+    updaters[url], options[url] = clock.Now(), opts
+    mu.Unlock()
+    t.Cleanup(func() {
+        mu.Lock()
+        forgetFeed(url)
+        mu.Unlock()
+    })
+}
+
+func TestEmptyArchivePolicies(t *testing.T) {
+    archives := map[string][]byte{
+        "/empty-body.xml.gz": nil,
+        "/empty-feed.xml.gz": gzipped(t, "<vacancies></vacancies>"),
+    }
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        feedHandler(archives[r.URL.Path])(w, r)
+    }))
+    defer srv.Close()
+
+    tests := []struct {
+        path     string
+        policy   checkPolicy
+        kind     failureKind
+        warnings []string
+    }{
+        {"/empty-body.xml.gz", policyOff, failureEmptyBody, nil},
+        {"/empty-body.xml.gz", policyWarn, failureEmptyBody, nil},
+        {"/empty-feed.xml.gz", policyOff, "", nil},
+        {"/empty-feed.xml.gz", policyWarn, "", []string{validationEmpty}},
+        {"/empty-feed.xml.gz", policyFail, failureEmpty, nil},
+    }
+    for _, test := range tests {
+        url := srv.URL + test.path
+        monitorForTest(t, url, FeedOptions{Validations: map[string]checkPolicy{validationEmpty: test.policy}})
+        err := updateInfoIfNeed(context.Background(), url, info)
+        mu.RLock()
+        fi, counted := info[url]
+        mu.RUnlock()
+        name := fmt.Sprintf("%s with %s policy", test.path, test.policy)
+        switch {
+        case test.kind != "":
+            if kind := classifyFailure(err); err == nil || kind != test.kind {
+                t.Errorf("%s: failed with %v (%q), expected %q", name, err, kind, test.kind)
+            }
+            if counted {
+                t.Errorf("%s: counted %d vacancies, expected none", name, fi.VacanciesCount)
+            }
+        case err != nil:
+            t.Errorf("%s: %v", name, err)
+        case !counted || fi.VacanciesCount != 0:
+            t.Errorf("%s: counted %v %d vacancies, expected an empty feed", name, counted, fi.VacanciesCount)
+        case !reflect.DeepEqual(fi.Warnings, test.warnings):
+            t.Errorf("%s: warnings are %v, expected %v", name, fi.Warnings, test.warnings)
+        }
+        mu.Lock()
+        forgetFeed(url)
+        mu.Unlock()
+    }
+}
+
+func TestCountVacanciesFromReaderFixtures(t *testing.T) {
+    tests := []struct {
+        fixture  string
+        opts     FeedOptions
+        expected int64
+    }{
+        {"selftest/feed.xml.gz", selftestOptions, selftestCount},
+        {"selftest/feed.xml.gz", FeedOptions{}, selftestCount + 5},
+        {"selftest/feed.xml.gz", FeedOptions{Parent: "vacancies"}, selftestCount},
+        {"testdata/plain.xml", FeedOptions{}, 4},
+        {"testdata/plain.xml", FeedOptions{Element: "{http://hh.ru/feed}vacancy"}, 1},
+        {"testdata/plain.xml", FeedOptions{Attr: "status!=archived"}, 3},
+        {"testdata/plain.xml", FeedOptions{Root: "source", Parent: "vacancies", Attr: "status=active"}, 2},
+    }
+    for _, test := range tests {
+        f, err := os.Open(test.fixture)
+        if err != nil {
+            t.Fatal(err)
+        }
+        count, err := CountVacanciesFromReader(context.Background(), f, test.opts)
+        f.Close()
+        if err != nil {
+            t.Errorf("%s with %+v: %v", test.fixture, test.opts, err)
+            continue
+        }
+        if count != test.expected {
+            t.Errorf("%s with %+v counted %d, expected %d", test.fixture, test.opts, count, test.expected)
+        }
+    }
+    if _, err := CountVacanciesFromReader(context.Background(), strings.NewReader("<vacancies><vacancy>"), FeedOptions{}); err == nil {
+        t.Error("a truncated feed is counted")
+    }
+}
+
+func TestCorruptGzipTrailer(t *testing.T) {
+    archive := gzipped(t, vacanciesXML(3))
+    // the CRC-32 starts the 8 bytes trailer
+    archive[len(archive)-8] ^= 0xff
+    srv := httptest.NewServer(feedHandler(archive))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+
+    cr, err := countVacancies(context.Background(), url, FeedOptions{}, nil)
+    if !errors.Is(err, gzip.ErrChecksum) || classifyFailure(err) != failureCorrupt {
+        t.Errorf("failed with %v (%q), expected a %q checksum failure", err, classifyFailure(err), failureCorrupt)
+    }
+    if !cr.TrailerCorrupt {
+        t.Error("the failed count isn't reported trailer corrupt")
+    }
+
+    cr, err = countVacancies(context.Background(), url, FeedOptions{TolerateCorruptTrailer: true}, nil)
+    if err != nil {
+        t.Fatalf("tolerating the trailer failed: %v", err)
+    }
+    if !cr.TrailerCorrupt || cr.VacanciesCount != 3 {
+        t.Errorf("tolerating the trailer counted %d, trailer corrupt %v, expected 3 and corrupt", cr.VacanciesCount, cr.TrailerCorrupt)
+    }
+    monitorForTest(t, url, FeedOptions{TolerateCorruptTrailer: true})
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+    mu.RLock()
+    defer mu.RUnlock()
+    if fi := info[url]; !fi.TrailerCorrupt || fi.VacanciesCount != 3 {
+        t.Errorf("feed info has %d vacancies, trailer corrupt %v, expected 3 and corrupt", fi.VacanciesCount, fi.TrailerCorrupt)
+    }
+}
+
+func TestCountVacanciesParent(t *testing.T) {
+    nested := `<source>
+  <vacancies>
+    <vacancy id="1"/>
+    <vacancy id="2"/>
+    <vacancy id="3"><related><vacancy id="103"/></related></vacancy>
+  </vacancies>
+  <archive><vacancy id="4"/></archive>
+  <vacancy id="5"/>
+</source>`
+    flat := `<vacancies><vacancy id="1"/><vacancy id="2"/></vacancies>`
+    namespaced := `<source xmlns:a="urn:a"><a:vacancies><vacancy/></a:vacancies><vacancies><vacancy/><vacancy/></vacancies></source>`
+    tests := []struct {
+        name, feed, parent string
+        expected           int64
+    }{
+        {"nested anywhere", nested, "", 6},
+        {"nested in parent", nested, "vacancies", 3},
+        {"nested in other parent", nested, "archive", 1},
+        {"nested in root", nested, "source", 1},
+        {"flat anywhere", flat, "", 2},
+        {"flat in parent", flat, "vacancies", 2},
+        {"flat in missing parent", flat, "source", 0},
+        {"namespaced any", namespaced, "vacancies", 3},
+        {"namespaced one", namespaced, "{urn:a}vacancies", 1},
+    }
+    for _, test := range tests {
+        count, err := CountVacanciesFromReader(context.Background(), strings.NewReader(test.feed), FeedOptions{Parent: test.parent})
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if count != test.expected {
+            t.Errorf("%s: counted %d, expected %d", test.name, count, test.expected)
+        }
+    }
+}
+
+func TestCountVacanciesWrongContentEncoding(t *testing.T) {
+    plain := vacanciesXML(3)
+    tests := []struct {
+        name, encoding string
+        body           []byte
+    }{
+        {"plain labeled gzip", "gzip", []byte(plain)},
+        {"archive labeled gzip", "gzip", gzipped(t, plain)},
+        {"archive labeled identity", "identity", gzipped(t, plain)},
+
+        {"plain labeled identity", "identity", []byte(plain)},
+    }
+    for _, test := range tests {
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Content-Type", "application/gzip")
+            w.Header().Set("Content-Encoding", test.encoding)
+            w.Write(test.body)
+        }))
+        cr, err := countVacancies(context.Background(), srv.URL+"/feed.xml.gz", FeedOptions{}, nil)
+        srv.Close()
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if cr.VacanciesCount != 3 {
+            t.Errorf("%s: counted %d, expected 3", test.name, cr.VacanciesCount)
+        }
+    }
+}
+
+func TestCountVacanciesChunked(t *testing.T) {
+    const total = 4000
+    archive := gzipped(t, vacanciesXML(total))
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/gzip")
+        // flushed writes without a Content-Length are sent chunked
+        for rest := archive; len(rest) > 0; {
+            n := min(len(rest), 1024)
+            w.Write(rest[:n])
+            w.(http.Flusher).Flush()
+            rest = rest[n:]
+        }
+    }))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    size := int64(len(archive))
+
+    cr, err := countVacancies(withArchiveSize(context.Background(), size), url, FeedOptions{}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !cr.Chunked || cr.Estimated || cr.VacanciesCount != total || cr.DownloadedBytes != size {
+        t.Errorf("counted chunked %v, estimated %v, %d vacancies of %d bytes, expected chunked, exact, %d of %d",
+            cr.Chunked, cr.Estimated, cr.VacanciesCount, cr.DownloadedBytes, total, size)
+    }
+
+    // the sample is extrapolated by the stat size, a chunked archive has no
+    // other, so twice the size estimates twice the count
+    opts := FeedOptions{SampleBytes: size / 4}
+    for _, statSize := range []int64{size, 2 * size} {
+        cr, err := countVacancies(withArchiveSize(context.Background(), statSize), url, opts, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        expected := total * statSize / size
+        if !cr.Chunked || !cr.Estimated || cr.DownloadedBytes >= size {
+            t.Errorf("stat size %d: counted chunked %v, estimated %v of %d bytes, expected a chunked estimate of a sample",
+                statSize, cr.Chunked, cr.Estimated, cr.DownloadedBytes)
+        }
+        if cr.VacanciesCount < expected*3/4 || cr.VacanciesCount > expected*5/4 {
+            t.Errorf("stat size %d: estimated %d vacancies, expected about %d", statSize, cr.VacanciesCount, expected)
+        }
+    }
+}
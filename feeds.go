@@ -0,0 +1,299 @@
+package main
+
+import (
+    "compress/gzip"
+    "encoding/base64"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+var maxRegistrationBody = flag.Int64("max-registration-body", 1<<20, "maximum size of a POST /feeds body, decompressed")
+
+// registrationWorkers limits the feeds of a POST /feeds probed at once.
+const registrationWorkers = 8
+
+// feedsHandler manages the set of monitored feeds.
+func feedsHandler(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet, http.MethodHead:
+        requireReadKey(listFeedsHandler)(w, r)
+    case http.MethodPost:
+        requireAPIKey(registerFeedsHandler)(w, r)
+    case http.MethodDelete:
+        requireAdminKey(deleteFeedsHandler)(w, r)
+    default:
+        w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+        w.WriteHeader(http.StatusMethodNotAllowed)
+    }
+}
+
+// listFeedsHandler serves GET /feeds?tag=TAG&match=any|all listing the
+// monitored feeds as /export does. They are sorted by url unless
+// sort=vacancies|size|lastUpdated|failureSince and order=asc|desc are set.
+func listFeedsHandler(w http.ResponseWriter, r *http.Request) {
+    filter, err := parseTagFilter(r.URL.Query())
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error() + "\n"))
+        return
+    }
+    // the snapshot is a copy, it's sorted after the lock is released
+    rows := exportSnapshot(filter)
+    if err := sortRows(rows, r.URL.Query()); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error() + "\n"))
+        return
+    }
+    rows, err = pageRows(w, r, rows)
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error() + "\n"))
+        return
+    }
+    writeJSON(w, http.StatusOK, rows)
+}
+
+const maxPageLimit = 1000
+
+// pageRows returns the page of rows asked by ?limit=N&cursor=C (or
+// offset=N), all of them without limit. X-Total-Count tells the number of
+// rows, X-Next-Cursor and a Link rel="next" the next page if there is one.
+// The cursor is the offset of the page, so paging is stable while the set
+// of feeds doesn't change.
+func pageRows(w http.ResponseWriter, r *http.Request, rows []exportRow) ([]exportRow, error) {
+    q := r.URL.Query()
+    if q.Get("limit") == "" {
+        return rows, nil
+    }
+    limit, err := strconv.Atoi(q.Get("limit"))
+    if err != nil || limit <= 0 || limit > maxPageLimit {
+        return nil, fmt.Errorf("Invalid limit %q: expected 1..%d", q.Get("limit"), maxPageLimit)
+    }
+    offset := 0
+    if v := q.Get("cursor"); v != "" {
+        b, err := base64.RawURLEncoding.DecodeString(v)
+        if err == nil {
+            offset, err = strconv.Atoi(string(b))
+        }
+        if err != nil || offset < 0 {
+            return nil, fmt.Errorf("Invalid cursor %q", v)
+        }
+    } else if v := q.Get("offset"); v != "" {
+        if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+            return nil, fmt.Errorf("Invalid offset %q", v)
+        }
+    }
+    w.Header().Set("X-Total-Count", strconv.Itoa(len(rows)))
+    if offset >= len(rows) {
+        return []exportRow{}, nil
+    }
+    end := min(offset+limit, len(rows))
+    if end < len(rows) {
+        cursor := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+        q.Del("offset")
+        q.Set("cursor", cursor)
+        w.Header().Set("X-Next-Cursor", cursor)
+        // relative to the request, the path is stripped of -path-prefix here
+        w.Header().Set("Link", fmt.Sprintf("<?%s>; rel=\"next\"", q.Encode()))
+    }
+    return rows[offset:end], nil
+}
+
+// deleteFeedsHandler serves DELETE /feeds?prefix=URL_PREFIX stopping
+// monitoring of every feed with the url starting with the prefix, which is
+// canonicalized as the feed urls are.
+func deleteFeedsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Query().Get("prefix") == "" {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte("prefix is required\n"))
+        return
+    }
+    prefix, err := canonicalPrefix(r.URL.Query().Get("prefix"))
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error() + "\n"))
+        return
+    }
+
+    mu.Lock()
+    removed := 0
+    for url := range updaters {
+        if strings.HasPrefix(url, prefix) {
+            log.Printf("stop monitoring %s - removed by prefix %s", url, prefix)
+            forgetFeed(url)
+            removed++
+        }
+    }
+    mu.Unlock()
+
+    if wantsJSON(r) {
+        writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+        return
+    }
+    w.Write([]byte(fmt.Sprintf("removed %d feeds\n", removed)))
+}
+
+// touchResponse tells which of the posted feeds were touched.
+type touchResponse struct {
+    Touched      []string `json:"touched"`
+    NotMonitored []string `json:"notMonitored"`
+}
+
+// touchFeedsHandler serves POST /feeds/touch with a JSON list of feed urls,
+// marking the monitored ones requested to keep them from idle eviction
+// without reading their info. Unknown urls are reported, not registered.
+func touchFeedsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", "POST")
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    var urls []string
+    body := io.LimitReader(r.Body, *maxRegistrationBody+1)
+    if err := json.NewDecoder(body).Decode(&urls); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(fmt.Sprintf("Error parsing urls, expected a JSON list: %v\n", err)))
+        return
+    }
+    resp := touchResponse{Touched: []string{}, NotMonitored: []string{}}
+    now := clock.Now()
+    mu.Lock()
+    for _, raw := range urls {
+        url, err := canonicalURL(raw)
+        if _, monitored := updaters[url]; err != nil || !monitored {
+            resp.NotMonitored = append(resp.NotMonitored, raw)
+            continue
+        }
+        updaters[url] = now
+        resp.Touched = append(resp.Touched, url)
+    }
+    mu.Unlock()
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// registrationResult is the outcome of a feed of POST /feeds. Duplicates
+// are the other entries of the feed collapsed into this one.
+type registrationResult struct {
+    URL        string   `json:"url"`
+    Result     string   `json:"result"`
+    Duplicates []string `json:"duplicates,omitempty"`
+}
+
+// registerFeedsHandler serves POST /feeds registering a JSON list of feeds,
+// as of the -feeds file. The body may be sent with Content-Encoding: gzip.
+func registerFeedsHandler(w http.ResponseWriter, r *http.Request) {
+    var body io.Reader = r.Body
+    switch enc := strings.ToLower(r.Header.Get("Content-Encoding")); enc {
+    case "", "identity":
+    case "gzip", "x-gzip":
+        gz, err := gzip.NewReader(r.Body)
+        if err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            w.Write([]byte(fmt.Sprintf("Error decompressing body: %v\n", err)))
+            return
+        }
+        body = gz
+    default:
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(fmt.Sprintf("unsupported Content-Encoding %q\n", enc)))
+        return
+    }
+    // the limit is of the decompressed body, so a gzip bomb is cut off too
+    b, err := io.ReadAll(io.LimitReader(body, *maxRegistrationBody+1))
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(fmt.Sprintf("Error reading body: %v\n", err)))
+        return
+    }
+    if int64(len(b)) > *maxRegistrationBody {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(fmt.Sprintf("body is over %d bytes\n", *maxRegistrationBody)))
+        return
+    }
+    feeds, duplicates, err := parseFeedList(b)
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(fmt.Sprintf("Error parsing feeds: %v\n", err)))
+        return
+    }
+
+    // a retried registration gets the results of the first one
+    key := r.Header.Get("Idempotency-Key")
+    if key != "" {
+        state, replayed := beginRegistration(key, registrationFeeds(feeds))
+        switch state {
+        case idempotencyReplay:
+            w.Header().Set("Idempotent-Replayed", "true")
+            writeJSON(w, http.StatusOK, replayed)
+            return
+        case idempotencyInProgress:
+            w.WriteHeader(http.StatusConflict)
+            w.Write([]byte("registration with this Idempotency-Key is in progress\n"))
+            return
+        case idempotencyConflict:
+            w.WriteHeader(http.StatusConflict)
+            w.Write([]byte("Idempotency-Key is used already for other feeds\n"))
+            return
+        }
+    }
+
+    ctx := traceContextFromRequest(r)
+    results := make([]registrationResult, len(feeds))
+    // feeds over the free slots couldn't be registered anyway, so they
+    // aren't probed
+    var probed []int
+    mu.RLock()
+    free := FeedsLimit - len(updaters)
+    for i, f := range feeds {
+        results[i].URL = f.URL
+        results[i].Duplicates = duplicates[f.URL]
+        if !cfg().hostAllowed(f.URL) {
+            results[i].Result = "forbidden"
+            continue
+        }
+        if _, monitored := updaters[f.URL]; !monitored {
+            if free <= 0 {
+                results[i].Result = "limit"
+                continue
+            }
+            free--
+        }
+        probed = append(probed, i)
+    }
+    mu.RUnlock()
+    var wg sync.WaitGroup
+    workers := make(chan struct{}, registrationWorkers)
+    for _, i := range probed {
+        wg.Add(1)
+        workers <- struct{}{}
+        go func(i int, f preregisteredFeed) {
+            defer func() {
+                <-workers
+                wg.Done()
+            }()
+            switch err := registerFeed(ctx, f.URL, f.FeedOptions); err {
+            case nil:
+                results[i].Result = "registered"
+            case errFeedNotAlive:
+                results[i].Result = "not-alive"
+            case errFeedsLimit:
+                results[i].Result = "limit"
+            default:
+                results[i].Result = "error"
+            }
+        }(i, feeds[i])
+    }
+    wg.Wait()
+    log.Printf("registered %d feeds posted", len(feeds))
+    if key != "" {
+        finishRegistration(key, results)
+    }
+    writeJSON(w, http.StatusOK, results)
+}
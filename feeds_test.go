@@ -0,0 +1,169 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "reflect"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRegisterFeedsCollapsesDuplicates(t *testing.T) {
+    forgetFeedsAfter(t)
+    srv := httptest.NewServer(feedHandler(gzipped(t, vacanciesXML(1))))
+    defer srv.Close()
+    feedURL, otherURL := srv.URL+"/feed.xml.gz", srv.URL+"/other.xml.gz"
+    // the same feed listed again as is, with a trailing slash, a fragment
+    // and a dot segment
+    body := fmt.Sprintf(`[{"url": %q}, {"url": %q}, {"url": %q}, {"url": %q}, {"url": %q}, {"url": %q}]`,
+        feedURL, otherURL, feedURL, feedURL+"/", feedURL+"#top", srv.URL+"/feeds/../feed.xml.gz")
+    w := httptest.NewRecorder()
+    registerFeedsHandler(w, httptest.NewRequest(http.MethodPost, "/feeds", strings.NewReader(body)))
+    if w.Code != http.StatusOK {
+        t.Fatalf("registration answered %d: %s", w.Code, w.Body)
+    }
+    var results []registrationResult
+    if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+        t.Fatal(err)
+    }
+    expected := []registrationResult{
+        {URL: feedURL, Result: "registered", Duplicates: []string{feedURL, feedURL + "/", feedURL + "#top", srv.URL + "/feeds/../feed.xml.gz"}},
+        {URL: otherURL, Result: "registered"},
+    }
+    if !reflect.DeepEqual(results, expected) {
+        t.Errorf("results are %+v, expected %+v", results, expected)
+    }
+    mu.RLock()
+    defer mu.RUnlock()
+    if len(updaters) != 2 {
+        t.Errorf("%d feeds monitored, expected 2", len(updaters))
+    }
+}
+
+func TestFeedsPagesBehindPathPrefix(t *testing.T) {
+    var expected []string
+    for _, name := range []string{"a", "b", "c"} {
+        feedURL := "http://example.com/" + name + ".xml.gz"
+        monitorForTest(t, feedURL, FeedOptions{})
+        expected = append(expected, feedURL)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/feeds", feedsHandler)
+    handler := withPathPrefix(mux, "/feed-monitor", nil)
+
+    var listed []string
+    page, _ := url.Parse("http://monitor.example.com/feed-monitor/feeds?limit=2&sort=size")
+    for pages := 0; page != nil; pages++ {
+        if pages == len(expected) {
+            t.Fatal("the pages don't end")
+        }
+        w := httptest.NewRecorder()
+        handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, page.String(), nil))
+        if w.Code != http.StatusOK {
+            t.Fatalf("%s answered %d: %s", page, w.Code, w.Body)
+        }
+        var rows []exportRow
+        if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+            t.Fatal(err)
+        }
+        for _, row := range rows {
+            listed = append(listed, row.URL)
+        }
+        link := w.Header().Get("Link")
+        if link == "" {
+            break
+        }
+        ref, ok := strings.CutSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+        next, err := page.Parse(ref)
+        if !ok || err != nil {
+            t.Fatalf("invalid Link %q", link)
+        }
+        if next.Path != "/feed-monitor/feeds" || next.Query().Get("sort") != "size" {
+            t.Errorf("next page of %s is %s, expected the prefixed path with the same sort", page, next)
+        }
+        page = next
+    }
+    if !reflect.DeepEqual(listed, expected) {
+        t.Errorf("pages listed %v, expected %v", listed, expected)
+    }
+}
+
+func TestDeleteFeedsNeedsKey(t *testing.T) {
+    const feedURL = "http://example.com/feed.xml.gz"
+    monitorForTest(t, feedURL, FeedOptions{})
+    prevKey := *apiKey
+    t.Cleanup(func() { *apiKey = prevKey })
+    deleteFeeds := func(prefix string) *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodDelete, "/feeds?prefix="+url.QueryEscape(prefix), nil)
+        r.Header.Set("X-API-Key", "secret")
+        w := httptest.NewRecorder()
+        feedsHandler(w, r)
+        return w
+    }
+
+    *apiKey = ""
+    if w := deleteFeeds("http"); w.Code != http.StatusForbidden {
+        t.Errorf("without a configured key answered %d, expected 403", w.Code)
+    }
+    *apiKey = "secret"
+    if w := deleteFeeds("http"); w.Code != http.StatusBadRequest {
+        t.Errorf("a prefix without a host answered %d, expected 400", w.Code)
+    }
+    if w := deleteFeeds("HTTP://Example.COM:80/"); w.Code != http.StatusOK || w.Body.String() != "removed 1 feeds\n" {
+        t.Errorf("a canonically equal prefix answered %d: %s", w.Code, w.Body)
+    }
+    mu.RLock()
+    defer mu.RUnlock()
+    if _, ok := updaters[feedURL]; ok {
+        t.Error("the feed is still monitored")
+    }
+}
+
+func TestRegisterFeedsBoundsProbes(t *testing.T) {
+    forgetFeedsAfter(t)
+    // the poll loops don't check the registered feeds
+    paused.Store(true)
+    t.Cleanup(func() { paused.Store(false) })
+    var probes, running, peak atomic.Int64
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.RawQuery == "stat" {
+            probes.Add(1)
+            n := running.Add(1)
+            defer running.Add(-1)
+            for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+        feedHandler(gzipped(t, vacanciesXML(1)))(w, r)
+    }))
+    defer srv.Close()
+    const posted = 3 * FeedsLimit
+    urls := make([]string, posted)
+    for i := range urls {
+        urls[i] = fmt.Sprintf(`{"url": "%s/feed-%d.xml.gz"}`, srv.URL, i)
+    }
+    w := httptest.NewRecorder()
+    registerFeedsHandler(w, httptest.NewRequest(http.MethodPost, "/feeds", strings.NewReader("["+strings.Join(urls, ",")+"]")))
+    var results []registrationResult
+    if err := json.Unmarshal(w.Body.Bytes(), &results); w.Code != http.StatusOK || err != nil {
+        t.Fatalf("registration answered %d: %s", w.Code, w.Body)
+    }
+    counts := make(map[string]int)
+    for _, r := range results {
+        counts[r.Result]++
+    }
+    if counts["registered"] != FeedsLimit || counts["limit"] != posted-FeedsLimit {
+        t.Errorf("results are %v, expected %d registered, the rest over the limit", counts, FeedsLimit)
+    }
+    if n := probes.Load(); n != FeedsLimit {
+        t.Errorf("probed %d times, expected %d", n, FeedsLimit)
+    }
+    if n := peak.Load(); n > registrationWorkers {
+        t.Errorf("%d probes at once, expected at most %d", n, registrationWorkers)
+    }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "hash/fnv"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+var maxAgeWait = flag.Duration("max-age-wait", 2*time.Second, "how long a request with maxAge waits for a refresh of stale info")
+
+// parseMaxAge accepts a duration like "30s" or a number of seconds.
+func parseMaxAge(s string) (time.Duration, error) {
+    if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+        return time.Duration(n) * time.Second, nil
+    }
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+        return 0, fmt.Errorf("Invalid maxAge %q: expected seconds or a duration like 30s", s)
+    }
+    return d, nil
+}
+
+// refreshFeed checks the feed out of turn, waiting for the check up to
+// -max-age-wait, and returns its info then.
+func refreshFeed(ctx context.Context, url string) FeedInfo {
+    ctx, cancel := context.WithTimeout(ctx, *maxAgeWait)
+    defer cancel()
+    if done, ok := requestCheck(ctx, url, false); ok {
+        select {
+        case <-done:
+        case <-ctx.Done():
+        }
+    }
+    mu.RLock()
+    defer mu.RUnlock()
+    return info[url]
+}
+
+// setInfoETag identifies the version of the info of a counted feed in ETag,
+// it changes with every check which updates or fails it.
+func setInfoETag(w http.ResponseWriter, fi FeedInfo) {
+    h := fnv.New64a()
+    fmt.Fprintf(h, "%d %d %d %t", fi.UpdatedAt.UnixNano(), fi.FailureSince.UnixNano(), fi.VacanciesCount, fi.Refreshing)
+    w.Header().Set("ETag", fmt.Sprintf(`"%x"`, h.Sum64()))
+}
+
+// setDataAge tells the age of the served info in X-Data-Age, in seconds.
+func setDataAge(w http.ResponseWriter, age time.Duration) {
+    w.Header().Set("X-Data-Age", strconv.FormatInt(int64(age.Seconds()), 10))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+var (
+    readyPinned         = flag.Bool("ready-pinned", false, "report not ready until every pinned feed is counted once")
+    readyPinnedDeadline = flag.Duration("ready-pinned-deadline", 10*time.Minute, "report ready after this long since the start even if pinned feeds aren't counted")
+)
+
+// startedAt is the start of the process, the pinned feeds gate gives up
+// -ready-pinned-deadline after it.
+var startedAt = clock.Now()
+
+// startupPinned are the pinned feeds of the -feeds file, the gate waits for
+// them before they are registered too.
+var startupPinned []string
+
+// pinnedGateOpen latches once the pinned feeds are counted or the deadline
+// passes, the gate is for the start only.
+var pinnedGateOpen atomic.Bool
+
+// loggedPending are the pending pinned feeds logged last, probes log them
+// again only when they change.
+var loggedPending atomic.Value
+
+// pendingPinned returns the pinned feeds not checked successfully since
+// the start. Info restored from -state-file doesn't pass the gate, a check
+// of this process must count the feed or confirm its count.
+func pendingPinned() []string {
+    mu.RLock()
+    defer mu.RUnlock()
+    pending := make(map[string]bool)
+    for _, url := range startupPinned {
+        pending[url] = true
+    }
+    for url, opts := range options {
+        if opts.Pinned {
+            pending[url] = true
+        }
+    }
+    var urls []string
+    for url := range pending {
+        if fi := info[url]; fi.CountedAt.IsZero() || fi.UpdatedAt.Before(startedAt) {
+            urls = append(urls, url)
+        }
+    }
+    sort.Strings(urls)
+    return urls
+}
+
+// pinnedReady tells if the pinned feeds gate is passed.
+func pinnedReady() (bool, []string) {
+    if !*readyPinned || pinnedGateOpen.Load() {
+        return true, nil
+    }
+    pending := pendingPinned()
+    joined := strings.Join(pending, ", ")
+    switch {
+    case len(pending) == 0:
+        if pinnedGateOpen.CompareAndSwap(false, true) {
+            log.Printf("pinned feeds are counted - ready")
+        }
+    case since(startedAt) >= *readyPinnedDeadline:
+        if pinnedGateOpen.CompareAndSwap(false, true) {
+            log.Printf("%d pinned feeds aren't counted in %v - ready anyway: %s", len(pending), *readyPinnedDeadline, joined)
+        }
+    default:
+        if loggedPending.Swap(joined) != joined {
+            log.Printf("not ready, %d pinned feeds aren't counted: %s", len(pending), joined)
+        }
+        return false, pending
+    }
+    return true, nil
+}
+
+// failingRatio returns the number of monitored feeds and the fraction of
+// them failing.
+func failingRatio() (monitored int, ratio float64) {
+    mu.RLock()
+    defer mu.RUnlock()
+    failing := 0
+    for url := range updaters {
+        if fi, ok := info[url]; ok && !fi.FailureSince.IsZero() {
+            failing++
+        }
+    }
+    if len(updaters) == 0 {
+        return 0, 0
+    }
+    return len(updaters), float64(failing) / float64(len(updaters))
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+    if ready, pending := pinnedReady(); !ready {
+        w.WriteHeader(http.StatusServiceUnavailable)
+        w.Write([]byte(fmt.Sprintf("%d pinned feeds aren't counted yet\n", len(pending))))
+        return
+    }
+    if maxFailing := cfg().ReadyMaxFailing; maxFailing > 0 {
+        monitored, ratio := failingRatio()
+        if monitored > 0 && ratio >= maxFailing {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.Write([]byte(fmt.Sprintf("%.0f%% of %d feeds are failing\n", ratio*100, monitored)))
+            return
+        }
+    }
+    w.Write([]byte("ok\n"))
+}
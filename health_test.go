@@ -0,0 +1,85 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+)
+
+func TestPinnedGateNeedsCheckSinceStart(t *testing.T) {
+    prev := *readyPinned
+    *readyPinned = true
+    t.Cleanup(func() {
+        *readyPinned = prev
+        pinnedGateOpen.Store(false)
+    })
+    archive := gzipped(t, vacanciesXML(2))
+    srv := httptest.NewServer(feedHandler(archive))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    monitorForTest(t, url, FeedOptions{Pinned: true})
+    // as restored from the state file: counted before the start
+    before := startedAt.Add(-1)
+    mu.Lock()
+    info[url] = FeedInfo{SizeBytes: int64(len(archive)), VacanciesCount: 2, CountedAt: before, UpdatedAt: before}
+    mu.Unlock()
+    readyz := func() int {
+        w := httptest.NewRecorder()
+        readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+        return w.Code
+    }
+
+    if code := readyz(); code != http.StatusServiceUnavailable {
+        t.Fatalf("with restored info only answered %d, expected 503", code)
+    }
+    // the size is the same, the check confirms the restored count
+    if err := updateInfoIfNeed(context.Background(), url, info); err != nil {
+        t.Fatal(err)
+    }
+    if code := readyz(); code != http.StatusOK {
+        t.Errorf("after a check answered %d, expected 200", code)
+    }
+}
+
+func TestPinnedGateLogsChanges(t *testing.T) {
+    prev := *readyPinned
+    *readyPinned = true
+    var logged bytes.Buffer
+    log.SetOutput(&logged)
+    t.Cleanup(func() {
+        *readyPinned = prev
+        pinnedGateOpen.Store(false)
+        loggedPending.Store("")
+        log.SetOutput(os.Stderr)
+    })
+    first, second := "http://example.com/first.xml.gz", "http://example.com/second.xml.gz"
+    monitorForTest(t, first, FeedOptions{Pinned: true})
+    probe := func(times int) int {
+        logged.Reset()
+        for i := 0; i < times; i++ {
+            readyzHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil))
+        }
+        return strings.Count(logged.String(), "\n")
+    }
+
+    if n := probe(3); n != 1 {
+        t.Errorf("3 probes logged %d lines, expected 1:\n%s", n, &logged)
+    }
+    monitorForTest(t, second, FeedOptions{Pinned: true})
+    if n := probe(3); n != 1 {
+        t.Errorf("3 probes after the pending feeds changed logged %d lines, expected 1:\n%s", n, &logged)
+    }
+    mu.Lock()
+    for _, url := range []string{first, second} {
+        info[url] = FeedInfo{CountedAt: clock.Now(), UpdatedAt: clock.Now()}
+    }
+    mu.Unlock()
+    if n := probe(3); n != 1 || !strings.Contains(logged.String(), "- ready") {
+        t.Errorf("3 probes after the feeds are counted logged %d lines, expected the ready one:\n%s", n, &logged)
+    }
+}
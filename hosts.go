@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "net/url"
+    "sync"
+)
+
+var (
+    maxConnsPerHost     = flag.Int("max-conns-per-host", 4, "maximum connections to a feed host, 0 - unlimited")
+    maxIdleConnsPerHost = flag.Int("max-idle-conns-per-host", 2, "idle connections kept to a feed host")
+    maxDownloadsPerHost = flag.Int("max-downloads-per-host", 2, "maximum archives downloaded from a host at once, 0 - unlimited")
+)
+
+// hostSlots limits concurrent archive downloads per host. With HTTP/2 many
+// downloads share one connection, so MaxConnsPerHost alone doesn't bound
+// the load of a host.
+var (
+    hostSlotsMu sync.Mutex
+    hostSlots   = make(map[string]*slots)
+)
+
+// acquireHost waits for a download slot of the host of feedURL, first counts
+// before recounts. The returned func releases it.
+func acquireHost(ctx context.Context, feedURL string) (release func(), err error) {
+    limit := *maxDownloadsPerHost
+    u, err := url.Parse(feedURL)
+    if limit <= 0 || err != nil {
+        return func() {}, nil
+    }
+    hostSlotsMu.Lock()
+    s, ok := hostSlots[u.Host]
+    if !ok {
+        s = newSlots(limit)
+        hostSlots[u.Host] = s
+    }
+    hostSlotsMu.Unlock()
+    if err := s.acquire(ctx, isFirstCount(ctx)); err != nil {
+        return nil, err
+    }
+    return s.release, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "flag"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    idempotencyTTL  = flag.Duration("idempotency-ttl", 24*time.Hour, "how long the result of a POST /feeds with an Idempotency-Key is replayed")
+    idempotencyKeys = flag.Int("idempotency-keys", 1000, "idempotency keys remembered, the oldest are dropped over it")
+)
+
+// idempotentRegistration is the outcome of a POST /feeds with a key, results
+// is nil while the registration runs.
+type idempotentRegistration struct {
+    feeds   string
+    results []registrationResult
+    at      time.Time
+}
+
+var (
+    idempotencyMu sync.Mutex
+    // registrations by key, keyOrder is oldest first for eviction
+    registrations = make(map[string]*idempotentRegistration)
+    keyOrder      []string
+)
+
+type idempotencyState int
+
+const (
+    idempotencyNew idempotencyState = iota
+    idempotencyReplay
+    idempotencyInProgress
+    idempotencyConflict
+)
+
+// registrationFeeds identifies the feeds of a registration, regardless of
+// their order.
+func registrationFeeds(feeds []preregisteredFeed) string {
+    urls := make([]string, len(feeds))
+    for i, f := range feeds {
+        urls[i] = f.URL
+    }
+    sort.Strings(urls)
+    return strings.Join(urls, "\n")
+}
+
+// beginRegistration claims key for the registration of feeds, or returns
+// the results of the one done with it.
+func beginRegistration(key, feeds string) (idempotencyState, []registrationResult) {
+    idempotencyMu.Lock()
+    defer idempotencyMu.Unlock()
+    now := clock.Now()
+    for len(keyOrder) > 0 {
+        reg, ok := registrations[keyOrder[0]]
+        if ok && now.Sub(reg.at) < *idempotencyTTL && len(keyOrder) < max(*idempotencyKeys, 1) {
+            break
+        }
+        delete(registrations, keyOrder[0])
+        keyOrder = keyOrder[1:]
+    }
+    if reg, ok := registrations[key]; ok {
+        switch {
+        case reg.feeds != feeds:
+            return idempotencyConflict, nil
+        case reg.results == nil:
+            return idempotencyInProgress, nil
+        default:
+            return idempotencyReplay, reg.results
+        }
+    }
+    registrations[key] = &idempotentRegistration{feeds: feeds, at: now}
+    keyOrder = append(keyOrder, key)
+    return idempotencyNew, nil
+}
+
+// finishRegistration stores the results of the registration with key.
+func finishRegistration(key string, results []registrationResult) {
+    idempotencyMu.Lock()
+    defer idempotencyMu.Unlock()
+    if reg, ok := registrations[key]; ok {
+        reg.results = results
+    }
+}
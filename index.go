@@ -0,0 +1,209 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "strings"
+)
+
+var maxIndexParts = flag.Int("max-index-parts", 100, "most parts an index feed may list")
+
+// maxIndexBytes limits the index document, it lists urls only.
+const maxIndexBytes = 1 << 20
+
+// indexPart is the count of a part of an index feed, Error is set if it
+// failed.
+type indexPart struct {
+    URL            string `json:"url"`
+    VacanciesCount int64  `json:"vacanciesCount"`
+    Error          string `json:"error,omitempty"`
+}
+
+// errNoParts fails an index which lists no parts.
+var errNoParts = errors.New("index lists no parts")
+
+// countIndex counts an index feed: url is a document listing the urls of its
+// parts, each an archive counted the usual way, their counts are summed.
+// The count fails only if every part fails, otherwise the failed ones are
+// reported in Parts.
+func countIndex(ctx context.Context, url string, opts FeedOptions, progress func(count, downloaded int64)) (cr countResult, err error) {
+    parts, host, err := fetchIndex(ctx, url, opts)
+    if err != nil {
+        return cr, err
+    }
+    cr.ArchiveHost = host
+    // the stat size is of the whole feed, not of a part
+    ctx = withArchiveSize(ctx, 0)
+    partOpts := opts
+    partOpts.Index = false
+    hash := sha256.New()
+    var firstErr error
+    for _, part := range parts {
+        var report func(count, downloaded int64)
+        if progress != nil {
+            base, baseBytes := cr.VacanciesCount, cr.DownloadedBytes
+            report = func(count, downloaded int64) { progress(base+count, baseBytes+downloaded) }
+        }
+        pr, err := countVacancies(ctx, part, partOpts, report)
+        if ctx.Err() != nil {
+            return countResult{}, fmt.Errorf("Error counting index %s: %w", url, ctx.Err())
+        }
+        if err != nil {
+            log.Printf("%s part %s failed: %v", url, part, err)
+            cr.Parts = append(cr.Parts, indexPart{URL: part, Error: redactError(err)})
+            cr.PartsFailed++
+            if firstErr == nil {
+                firstErr = err
+            }
+            continue
+        }
+        cr.Parts = append(cr.Parts, indexPart{URL: part, VacanciesCount: pr.VacanciesCount})
+        cr.VacanciesCount += pr.VacanciesCount
+        cr.RawCount += pr.RawCount
+        cr.DownloadedBytes += pr.DownloadedBytes
+        cr.Chunked = cr.Chunked || pr.Chunked
+        cr.Estimated = cr.Estimated || pr.Estimated
+        cr.TrailerCorrupt = cr.TrailerCorrupt || pr.TrailerCorrupt
+        cr.SizeBudgetExceeded = cr.SizeBudgetExceeded || pr.SizeBudgetExceeded
+        cr.PeakThroughput = max(cr.PeakThroughput, pr.PeakThroughput)
+        cr.AvgThroughput += pr.AvgThroughput
+        if pr.GeneratedAt.After(cr.GeneratedAt) {
+            cr.GeneratedAt = pr.GeneratedAt
+        }
+        if cr.ContentLanguage == "" {
+            cr.ContentLanguage = pr.ContentLanguage
+        }
+        hash.Write([]byte(part + " " + pr.ContentHash + "\n"))
+    }
+    if cr.PartsFailed == len(parts) {
+        return countResult{}, fmt.Errorf("Error counting index %s: all %d parts failed, the first: %w", url, len(parts), firstErr)
+    }
+    // failed parts have no throughput to average in
+    cr.AvgThroughput /= float64(len(parts) - cr.PartsFailed)
+    // the content is only known when every part is
+    if cr.PartsFailed == 0 {
+        cr.ContentHash = hex.EncodeToString(hash.Sum(nil))
+    }
+    return cr, nil
+}
+
+// fetchIndex downloads the index of url and returns the urls of its parts,
+// host is the one which served it after redirects.
+func fetchIndex(ctx context.Context, url string, opts FeedOptions) (parts []string, host string, err error) {
+    ctx, cancel := withRequestTimeout(ctx, opts)
+    defer cancel()
+    release, err := acquireHost(ctx, url)
+    if err != nil {
+        return nil, "", fmt.Errorf("Error waiting to download index %s: %v", url, err)
+    }
+    defer release()
+    req, err := newFeedRequest(ctx, url, opts)
+    if err != nil {
+        return nil, "", fmt.Errorf("Error fetching index from %s: %v", url, err)
+    }
+    res, err := doFeedRequest(req)
+    if err == nil && res.StatusCode >= 300 {
+        res.Body.Close()
+        err = &statusError{URL: url, Status: res.Status, Code: res.StatusCode, Archive: true, RetryAfter: retryAfter(res)}
+    }
+    if err != nil {
+        return nil, "", fmt.Errorf("Error fetching index from %s: %w", url, err)
+    }
+    defer res.Body.Close()
+    body, err := io.ReadAll(io.LimitReader(res.Body, maxIndexBytes+1))
+    if err != nil {
+        return nil, "", fmt.Errorf("Error fetching index from %s: %v", url, err)
+    }
+    if len(body) > maxIndexBytes {
+        return nil, "", fmt.Errorf("Error parsing index %s: more than %d bytes", url, maxIndexBytes)
+    }
+    refs, err := parseIndex(body)
+    if err != nil {
+        return nil, "", fmt.Errorf("Error parsing index %s: %w", url, err)
+    }
+    if len(refs) > max(*maxIndexParts, 1) {
+        return nil, "", fmt.Errorf("Error parsing index %s: %d parts, at most %d are counted", url, len(refs), *maxIndexParts)
+    }
+    base := res.Request.URL
+    for _, ref := range refs {
+        u, err := base.Parse(ref)
+        if err != nil {
+            return nil, "", fmt.Errorf("Error parsing index %s: %v", url, err)
+        }
+        part, err := canonicalURL(u.String())
+        if err != nil {
+            return nil, "", fmt.Errorf("Error parsing index %s: %v", url, err)
+        }
+        if !cfg().hostAllowed(part) {
+            return nil, "", fmt.Errorf("Error parsing index %s: host of part %s isn't allowed", url, part)
+        }
+        parts = append(parts, part)
+    }
+    return parts, res.Request.URL.Host, nil
+}
+
+// parseIndex reads the part references of an index: a JSON array of urls or
+// an object with one under "parts", or XML with the urls in <part> or, as in
+// sitemap indexes, <loc> elements.
+func parseIndex(body []byte) (refs []string, err error) {
+    trimmed := bytes.TrimSpace(body)
+    switch {
+    case bytes.HasPrefix(trimmed, []byte("[")):
+        err = json.Unmarshal(trimmed, &refs)
+    case bytes.HasPrefix(trimmed, []byte("{")):
+        var index struct {
+            Parts []string `json:"parts"`
+        }
+        err = json.Unmarshal(trimmed, &index)
+        refs = index.Parts
+    default:
+        refs, err = parseXMLIndex(trimmed)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if len(refs) == 0 {
+        return nil, errNoParts
+    }
+    return refs, nil
+}
+
+func parseXMLIndex(body []byte) (refs []string, err error) {
+    decoder := xml.NewDecoder(bytes.NewReader(body))
+    var text *strings.Builder
+    for {
+        t, err := decoder.Token()
+        if err == io.EOF {
+            return refs, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        switch se := t.(type) {
+        case xml.StartElement:
+            if se.Name.Local == "part" || se.Name.Local == "loc" {
+                text = &strings.Builder{}
+            }
+        case xml.CharData:
+            if text != nil {
+                text.Write(se)
+            }
+        case xml.EndElement:
+            if text != nil && (se.Name.Local == "part" || se.Name.Local == "loc") {
+                if ref := strings.TrimSpace(text.String()); ref != "" {
+                    refs = append(refs, ref)
+                }
+                text = nil
+            }
+        }
+    }
+}
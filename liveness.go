@@ -0,0 +1,84 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+)
+
+// probeBytes are read from the start of the archive of a liveness-only feed.
+const probeBytes = 4096
+
+// errNotFeed fails the probe of an archive which doesn't start as gzipped
+// XML.
+var errNotFeed = errors.New("archive doesn't start as gzipped XML")
+
+// probeArchive checks the first bytes of the archive of a liveness-only
+// feed instead of counting it: they should be gzip inflating to the start
+// of an XML document.
+func probeArchive(ctx context.Context, url string, opts FeedOptions) (cr countResult, err error) {
+    ctx, cancel := withRequestTimeout(ctx, opts)
+    defer cancel()
+    release, err := acquireHost(ctx, url)
+    if err != nil {
+        return cr, fmt.Errorf("Error waiting to probe %s: %v", url, err)
+    }
+    defer release()
+    req, err := newFeedRequest(ctx, url, opts)
+    if err != nil {
+        return cr, fmt.Errorf("Error probing archive %s: %v", url, err)
+    }
+    // a server ignoring the range sends it all, only the head is read then
+    req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", probeBytes-1))
+    req.Header.Set("Accept-Encoding", "identity")
+    res, err := doFeedRequest(req)
+    if err == nil && res.StatusCode >= 300 {
+        res.Body.Close()
+        err = &statusError{URL: url, Status: res.Status, Code: res.StatusCode, Archive: true}
+    }
+    if err == nil && !contentTypeAllowed(res.Header.Get("Content-Type"), cfg().ArchiveContentTypes) {
+        if err = validate(ctx, url, opts, validationContentType, &contentTypeError{URL: url, ContentType: res.Header.Get("Content-Type")}); err != nil {
+            res.Body.Close()
+        }
+    }
+    if err != nil {
+        return cr, fmt.Errorf("Error probing archive %s: %w", url, err)
+    }
+    defer res.Body.Close()
+    cr.ContentLanguage = res.Header.Get("Content-Language")
+    cr.ArchiveHost = res.Request.URL.Host
+
+    head, err := io.ReadAll(io.LimitReader(res.Body, probeBytes))
+    if err != nil {
+        return cr, fmt.Errorf("Error probing archive %s: %v", url, err)
+    }
+    if len(head) == 0 {
+        return cr, fmt.Errorf("Error probing archive %s: %w", url, errEmptyBody)
+    }
+    gz, err := gzip.NewReader(bytes.NewReader(head))
+    if err != nil {
+        // the plain feed of a server with wrong headers is counted too
+        if looksLikeXML(head) {
+            return cr, nil
+        }
+        return cr, fmt.Errorf("Error probing archive %s: %w", url, errNotFeed)
+    }
+    if mt := gz.Header.ModTime; !mt.IsZero() && mt.Unix() > 0 {
+        cr.GeneratedAt = mt
+    }
+    // the head is cut off anywhere, an error after some output is expected
+    plain := make([]byte, 64)
+    n, _ := io.ReadFull(gz, plain)
+    if !looksLikeXML(plain[:n]) {
+        return cr, fmt.Errorf("Error probing archive %s: %w", url, errNotFeed)
+    }
+    return cr, nil
+}
+
+// looksLikeXML tells if b starts as an XML document.
+func looksLikeXML(b []byte) bool {
+    return bytes.HasPrefix(bytes.TrimLeft(b, " \t\r\n\ufeff"), []byte("<"))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+// withConfig changes the config for the test, the previous one is restored
+// after it.
+func withConfig(t *testing.T, change func(c *Config)) {
+    t.Helper()
+    prev := config.Load()
+    c := *cfg()
+    change(&c)
+    config.Store(&c)
+    t.Cleanup(func() { config.Store(prev) })
+}
+
+// forgetFeedsAfter stops monitoring of the feeds registered by the test when
+// it ends and waits for their poll loops to return.
+func forgetFeedsAfter(t *testing.T) {
+    t.Cleanup(func() {
+        mu.Lock()
+        for url := range updaters {
+            forgetFeed(url)
+        }
+        mu.Unlock()
+        waitFor(t, "poll loops to stop", func() bool { return activePolls.Load() == 0 })
+    })
+}
+
+// waitFor polls cond until it's true, failing the test after 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); !cond(); {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+// waitCounted waits for the first count of a monitored feed.
+func waitCounted(t *testing.T, url string) FeedInfo {
+    t.Helper()
+    var fi FeedInfo
+    waitFor(t, "the count of "+url, func() bool {
+        mu.RLock()
+        defer mu.RUnlock()
+        var ok bool
+        fi, ok = info[url]
+        return ok
+    })
+    return fi
+}
+
+// vacanciesXML is a plain feed of n vacancies.
+func vacanciesXML(n int) string {
+    var b strings.Builder
+    b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n<vacancies>\n")
+    for i := 0; i < n; i++ {
+        fmt.Fprintf(&b, "  <vacancy id=\"%d\"><name>vacancy %d</name></vacancy>\n", i, i)
+    }
+    b.WriteString("</vacancies>\n")
+    return b.String()
+}
+
+// gzipped compresses s into a single gzip member.
+func gzipped(t *testing.T, s string) []byte {
+    t.Helper()
+    var b bytes.Buffer
+    zw := gzip.NewWriter(&b)
+    if _, err := zw.Write([]byte(s)); err != nil {
+        t.Fatal(err)
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return b.Bytes()
+}
+
+// feedHandler serves a feed: the stat with the archive size on ?stat, the
+// archive on any other request.
+func feedHandler(archive []byte) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.RawQuery == "stat" {
+            fmt.Fprintf(w, "size:%d bytes\nupdated:%s\n", len(archive), time.Now().Format(time.RFC3339))
+            return
+        }
+        w.Header().Set("Content-Type", "application/gzip")
+        w.Write(archive)
+    }
+}
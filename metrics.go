@@ -0,0 +1,174 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var durationWindowLen = flag.Int("duration-window", 64, "recent counts of a feed its count duration quantiles are computed over")
+
+// durationWindow keeps the durations of the recent counts of a feed: once
+// full, every count replaces the oldest one, so old samples don't outweigh
+// new ones. Count and Sum are of all counts since the feed is monitored.
+type durationWindow struct {
+    samples []time.Duration
+    next    int
+    Count   int64
+    Sum     time.Duration
+}
+
+func (w *durationWindow) add(d time.Duration) {
+    w.Count++
+    w.Sum += d
+    size := max(*durationWindowLen, 1)
+    if len(w.samples) < size {
+        w.samples = append(w.samples, d)
+        return
+    }
+    w.samples[w.next] = d
+    w.next = (w.next + 1) % len(w.samples)
+}
+
+// quantiles returns the nearest-rank quantiles of the window.
+func (w *durationWindow) quantiles(qs ...float64) []time.Duration {
+    sorted := append([]time.Duration(nil), w.samples...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    result := make([]time.Duration, len(qs))
+    if len(sorted) == 0 {
+        return result
+    }
+    for i, q := range qs {
+        rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+        result[i] = sorted[max(rank, 0)]
+    }
+    return result
+}
+
+// countDurations holds the duration windows of monitored feeds, guarded by
+// mu. A feed's window is dropped when it's forgotten.
+var countDurations = make(map[string]*durationWindow)
+
+// recordCountDuration adds a count of url to its window and the histogram,
+// traceID is of the check if it's traced. mu must be held.
+func recordCountDuration(url string, d time.Duration, traceID string) {
+    countHistogram.observe(d, traceID)
+    w, ok := countDurations[url]
+    if !ok {
+        w = &durationWindow{}
+        countDurations[url] = w
+    }
+    w.add(d)
+}
+
+var summaryQuantiles = []float64{0.5, 0.95, 0.99}
+
+// metricsHandler serves /metrics in the Prometheus text format, or in
+// OpenMetrics when the scraper prefers it, with exemplars when they are
+// enabled.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+    openMetrics := prefersOpenMetrics(r.Header.Get("Accept"))
+    w.Header().Set("Vary", "Accept")
+    var b strings.Builder
+    b.WriteString("# HELP feed_count_duration_seconds Duration of the recent vacancies counts of a feed.\n")
+    b.WriteString("# TYPE feed_count_duration_seconds summary\n")
+    mu.RLock()
+    urls := make([]string, 0, len(countDurations))
+    for url := range countDurations {
+        urls = append(urls, url)
+    }
+    sort.Strings(urls)
+    for _, url := range urls {
+        window := countDurations[url]
+        label := escapeLabel(url)
+        for i, d := range window.quantiles(summaryQuantiles...) {
+            fmt.Fprintf(&b, "feed_count_duration_seconds{url=\"%s\",quantile=\"%g\"} %g\n", label, summaryQuantiles[i], d.Seconds())
+        }
+        fmt.Fprintf(&b, "feed_count_duration_seconds_sum{url=\"%s\"} %g\n", label, window.Sum.Seconds())
+        fmt.Fprintf(&b, "feed_count_duration_seconds_count{url=\"%s\"} %d\n", label, window.Count)
+    }
+    updatersLen, infoLen, limit, headroom := mapSizes()
+    for _, g := range []struct {
+        name, help string
+        value      int
+    }{
+        {"feeds_monitored", "Feeds monitored, the size of updaters.", updatersLen},
+        {"feeds_info_entries", "Feeds having info, the size of info.", infoLen},
+        {"feeds_limit", "Maximum number of monitored feeds.", limit},
+        {"feeds_limit_headroom", "Feeds which can be registered before the limit is exhausted.", headroom},
+    } {
+        fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value)
+    }
+    b.WriteString("# HELP feed_check_success_ratio Share of successful checks of a feed within the reliability window.\n")
+    b.WriteString("# TYPE feed_check_success_ratio gauge\n")
+    urls = urls[:0]
+    for url := range checkTallies {
+        urls = append(urls, url)
+    }
+    sort.Strings(urls)
+    for _, url := range urls {
+        if percent, _, _ := reliability(url, clock.Now()); percent != nil {
+            fmt.Fprintf(&b, "feed_check_success_ratio{url=\"%s\"} %g\n", escapeLabel(url), *percent/100)
+        }
+    }
+    countHistogram.write(&b, openMetrics && *metricsExemplars)
+    mu.RUnlock()
+    for _, c := range []struct {
+        name, help string
+        value      int64
+    }{
+        {"feeds_checks", "Checks of all feeds.", totalChecks.Load()},
+        {"feeds_check_failures", "Failed checks of all feeds.", failedChecks.Load()},
+        {"feeds_downloaded_bytes", "Archive bytes read by counts of all feeds.", downloadedBytes.Load()},
+    } {
+        // OpenMetrics names the family without the _total suffix
+        family := c.name + "_total"
+        if openMetrics {
+            family = c.name
+        }
+        fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s_total %d\n", family, c.help, family, c.name, c.value)
+    }
+    if openMetrics {
+        b.WriteString("# EOF\n")
+        w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+    } else {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    }
+    w.Write([]byte(b.String()))
+}
+
+// prefersOpenMetrics tells if an Accept header ranks OpenMetrics over the
+// Prometheus text format, e.g. "application/openmetrics-text;
+// version=1.0.0,text/plain;version=0.0.4;q=0.5" of Prometheus.
+func prefersOpenMetrics(accept string) bool {
+    var openMetrics, text float64
+    for _, entry := range strings.Split(accept, ",") {
+        params := strings.Split(entry, ";")
+        q := 1.0
+        for _, p := range params[1:] {
+            if name, value, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.TrimSpace(name) == "q" {
+                if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+                    q = v
+                }
+            }
+        }
+        switch strings.ToLower(strings.TrimSpace(params[0])) {
+        case "application/openmetrics-text":
+            openMetrics = max(openMetrics, q)
+        case "text/plain":
+            text = max(text, q)
+        }
+    }
+    return openMetrics > 0 && openMetrics >= text
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+    return labelEscaper.Replace(s)
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+    "encoding/xml"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// FeedOptions are set for a feed when its monitoring starts.
+type FeedOptions struct {
+    // Element overrides -vacancy-element for the feed, Parent -
+    // -vacancy-parent, Root - -root-element.
+    Element string `json:"element,omitempty"`
+    Parent  string `json:"parent,omitempty"`
+    Root    string `json:"root,omitempty"`
+    // Attr overrides -vacancy-attr, the attribute rule counted elements
+    // must pass.
+    Attr string `json:"attr,omitempty"`
+    // MaxSize is the archive size budget in bytes, the feed is flagged when
+    // a download is over it and, with AbortOverBudget, the count fails.
+    MaxSize         int64 `json:"maxSize,omitempty"`
+    AbortOverBudget bool  `json:"abortOverBudget,omitempty"`
+    // Verify counts the feed twice to detect nondeterministic content.
+    Verify bool `json:"verify,omitempty"`
+    // LivenessOnly checks the feed is up without counting it: its stat is
+    // fetched and, with ProbeArchive, the archive head is checked to be
+    // gzipped XML.
+    LivenessOnly bool `json:"livenessOnly,omitempty"`
+    ProbeArchive bool `json:"probeArchive,omitempty"`
+    // Index makes the feed url an index listing the archives of the feed
+    // parts, which counts are summed.
+    Index bool `json:"index,omitempty"`
+    // Paged follows the next links of the feed pages, up to
+    // -max-feed-pages, and sums their counts.
+    Paged bool `json:"paged,omitempty"`
+    // SizeFallback probes the archive for its size when the feed has no
+    // stat.
+    SizeFallback bool `json:"sizeFallback,omitempty"`
+    // TolerateCorruptTrailer accepts the count of an archive which gzip
+    // trailer is missing or broken after the complete feed.
+    TolerateCorruptTrailer bool `json:"tolerateCorruptTrailer,omitempty"`
+    // SampleBytes estimates the count from this many first bytes of the
+    // archive, 0 - count it all.
+    SampleBytes int64 `json:"sampleBytes,omitempty"`
+    // Timeout overrides -request-timeout for the feed, up to
+    // -max-feed-timeout.
+    Timeout *Duration `json:"timeout,omitempty"`
+    // Headers are added to every request of the feed.
+    Headers map[string]string `json:"headers,omitempty"`
+    // Cookies keeps the cookies the feed servers set during a check and
+    // sends them with its later requests, e.g. a session set by the stat.
+    Cookies bool `json:"cookies,omitempty"`
+    // Proxy overrides -proxy for the feed. It may hold credentials, log it
+    // redacted only.
+    Proxy string `json:"proxy,omitempty"`
+    // Tags group feeds for filtering of listings and stats.
+    Tags []string `json:"tags,omitempty"`
+    // Language is sent as Accept-Language to get a localized feed.
+    Language string `json:"language,omitempty"`
+    // Pinned feeds aren't forgotten when not requested.
+    Pinned bool `json:"pinned,omitempty"`
+    // TreatEmptyAsFailure overrides -treat-empty-as-failure for the feed.
+    TreatEmptyAsFailure *bool `json:"treatEmptyAsFailure,omitempty"`
+    // Validations override the configured policies for the feed.
+    Validations map[string]checkPolicy `json:"validations,omitempty"`
+}
+
+// validate checks options read from a file, header names are canonicalized.
+func (opts *FeedOptions) validate() error {
+    for _, s := range []string{opts.Element, opts.Parent, opts.Root} {
+        if s != "" {
+            if _, err := parseElementName(s); err != nil {
+                return err
+            }
+        }
+    }
+    if opts.Attr != "" {
+        if _, err := parseAttrRule(opts.Attr); err != nil {
+            return err
+        }
+    }
+    if err := validatePolicies(opts.Validations); err != nil {
+        return err
+    }
+    if opts.Language != "" {
+        if err := validateLanguage(opts.Language); err != nil {
+            return err
+        }
+    }
+    if opts.MaxSize < 0 {
+        return fmt.Errorf("Invalid maxSize %d", opts.MaxSize)
+    }
+    if opts.SampleBytes < 0 {
+        return fmt.Errorf("Invalid sampleBytes %d", opts.SampleBytes)
+    }
+    if opts.Timeout != nil {
+        if err := validateFeedTimeout(opts.Timeout.Duration); err != nil {
+            return err
+        }
+    }
+    if opts.Proxy != "" {
+        if _, err := parseProxyURL(opts.Proxy); err != nil {
+            return err
+        }
+    }
+    for _, tag := range opts.Tags {
+        if err := validateTag(tag); err != nil {
+            return err
+        }
+    }
+    headers := opts.Headers
+    opts.Headers = nil
+    for name, value := range headers {
+        name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+        if err := validateHeader(name, value); err != nil {
+            return err
+        }
+        if reservedHeaders[name] {
+            continue
+        }
+        if opts.Headers == nil {
+            opts.Headers = make(map[string]string)
+        }
+        opts.Headers[name] = value
+    }
+    return nil
+}
+
+// parseFeedOptions reads feed options from registration query parameters.
+func parseFeedOptions(values url.Values) (opts FeedOptions, err error) {
+    return applyFeedOptions(FeedOptions{}, values)
+}
+
+// applyFeedOptions sets the options given in query parameters over opts,
+// the ones not given are kept. Given tags and headers replace all of them.
+func applyFeedOptions(opts FeedOptions, values url.Values) (FeedOptions, error) {
+    var err error
+    if values.Has("element") {
+        if opts.Element = values.Get("element"); opts.Element != "" {
+            if _, err = parseElementName(opts.Element); err != nil {
+                return opts, err
+            }
+        }
+    }
+    if values.Has("parent") {
+        if opts.Parent = values.Get("parent"); opts.Parent != "" {
+            if _, err = parseElementName(opts.Parent); err != nil {
+                return opts, err
+            }
+        }
+    }
+    if values.Has("attr") {
+        if opts.Attr = values.Get("attr"); opts.Attr != "" {
+            if _, err = parseAttrRule(opts.Attr); err != nil {
+                return opts, err
+            }
+        }
+    }
+    if values.Has("root") {
+        if opts.Root = values.Get("root"); opts.Root != "" {
+            if _, err = parseElementName(opts.Root); err != nil {
+                return opts, err
+            }
+        }
+    }
+    if v := values.Get("maxSize"); v != "" {
+        if opts.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || opts.MaxSize < 0 {
+            return opts, fmt.Errorf("Invalid maxSize %q: expected number of bytes", v)
+        }
+    }
+    if v := values.Get("abortOverBudget"); v != "" {
+        if opts.AbortOverBudget, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid abortOverBudget %q", v)
+        }
+    }
+    if v := values.Get("verify"); v != "" {
+        if opts.Verify, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid verify %q", v)
+        }
+    }
+    if v := values.Get("livenessOnly"); v != "" {
+        if opts.LivenessOnly, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid livenessOnly %q", v)
+        }
+    }
+    if v := values.Get("probeArchive"); v != "" {
+        if opts.ProbeArchive, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid probeArchive %q", v)
+        }
+    }
+    if v := values.Get("index"); v != "" {
+        if opts.Index, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid index %q", v)
+        }
+    }
+    if v := values.Get("cookies"); v != "" {
+        if opts.Cookies, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid cookies %q", v)
+        }
+    }
+    if v := values.Get("paged"); v != "" {
+        if opts.Paged, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid paged %q", v)
+        }
+    }
+    if v := values.Get("sampleBytes"); v != "" {
+        if opts.SampleBytes, err = strconv.ParseInt(v, 10, 64); err != nil || opts.SampleBytes < 0 {
+            return opts, fmt.Errorf("Invalid sampleBytes %q: expected number of bytes", v)
+        }
+    }
+    if v := values.Get("tolerateCorruptTrailer"); v != "" {
+        if opts.TolerateCorruptTrailer, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid tolerateCorruptTrailer %q", v)
+        }
+    }
+    if v := values.Get("sizeFallback"); v != "" {
+        if opts.SizeFallback, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid sizeFallback %q", v)
+        }
+    }
+    if v := values.Get("timeout"); v != "" {
+        timeout, err := time.ParseDuration(v)
+        if err != nil {
+            return opts, fmt.Errorf("Invalid timeout %q: expected duration like 5m", v)
+        }
+        if err = validateFeedTimeout(timeout); err != nil {
+            return opts, err
+        }
+        opts.Timeout = &Duration{timeout}
+    }
+    if v := values.Get("proxy"); v != "" {
+        if _, err = parseProxyURL(v); err != nil {
+            return opts, err
+        }
+        opts.Proxy = v
+    }
+    if values.Has("lang") {
+        if opts.Language = values.Get("lang"); opts.Language != "" {
+            if err = validateLanguage(opts.Language); err != nil {
+                return opts, err
+            }
+        }
+    }
+    if v := values.Get("pinned"); v != "" {
+        if opts.Pinned, err = strconv.ParseBool(v); err != nil {
+            return opts, fmt.Errorf("Invalid pinned %q", v)
+        }
+    }
+    if v := values.Get("treatEmptyAsFailure"); v != "" {
+        empty, err := strconv.ParseBool(v)
+        if err != nil {
+            return opts, fmt.Errorf("Invalid treatEmptyAsFailure %q", v)
+        }
+        opts.TreatEmptyAsFailure = &empty
+    }
+    if v := values.Get("validations"); v != "" {
+        if opts.Validations, err = parsePolicies(v); err != nil {
+            return opts, err
+        }
+    }
+    if values.Has("tag") {
+        opts.Tags = nil
+    }
+    for _, tag := range values["tag"] {
+        if err = validateTag(tag); err != nil {
+            return opts, err
+        }
+        opts.Tags = append(opts.Tags, tag)
+    }
+    if values.Has("header") {
+        opts.Headers = nil
+    }
+    for _, h := range values["header"] {
+        name, value, ok := strings.Cut(h, ":")
+        if !ok {
+            return opts, fmt.Errorf("Invalid header %q: expected Name: value", h)
+        }
+        name, value = http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value)
+        if err = validateHeader(name, value); err != nil {
+            return opts, err
+        }
+        if reservedHeaders[name] {
+            log.Printf("header %s is set by the transport - drop it", name)
+            continue
+        }
+        if opts.Headers == nil {
+            opts.Headers = make(map[string]string)
+        }
+        opts.Headers[name] = value
+    }
+    return opts, nil
+}
+
+func validateFeedTimeout(timeout time.Duration) error {
+    if timeout <= 0 {
+        return fmt.Errorf("Invalid timeout %v: should be positive", timeout)
+    }
+    if max := cfg().MaxFeedTimeout.Duration; max > 0 && timeout > max {
+        return fmt.Errorf("Invalid timeout %v: over the maximum of %v", timeout, max)
+    }
+    return nil
+}
+
+// timeout is of every request to the feed, 0 - no limit.
+func (opts FeedOptions) timeout() time.Duration {
+    if opts.Timeout != nil {
+        return opts.Timeout.Duration
+    }
+    return cfg().RequestTimeout.Duration
+}
+
+// reservedHeaders are managed by the transport, they are dropped from the
+// headers of a feed.
+var reservedHeaders = map[string]bool{
+    "Host":              true,
+    "Connection":        true,
+    "Content-Length":    true,
+    "Keep-Alive":        true,
+    "Proxy-Connection":  true,
+    "Transfer-Encoding": true,
+    "Te":                true,
+    "Trailer":           true,
+    "Upgrade":           true,
+}
+
+func validateHeader(name, value string) error {
+    if name == "" {
+        return fmt.Errorf("Invalid header: empty name")
+    }
+    for _, r := range name {
+        if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+            return fmt.Errorf("Invalid header name %q", name)
+        }
+    }
+    for _, r := range value {
+        if r == 0x7f || (r < ' ' && r != '\t') {
+            return fmt.Errorf("Invalid value of header %s", name)
+        }
+    }
+    return nil
+}
+
+// languageRe is a BCP 47 language tag like "ru" or "en-US".
+var languageRe = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+func validateLanguage(lang string) error {
+    if !languageRe.MatchString(lang) {
+        return fmt.Errorf("Invalid language tag %q", lang)
+    }
+    return nil
+}
+
+// sensitiveHeaderWords mark headers which values aren't shown in logs and
+// listings.
+var sensitiveHeaderWords = []string{"auth", "cookie", "token", "secret", "key", "password", "session"}
+
+// redactedHeaders returns the feed headers safe to show.
+func (opts FeedOptions) redactedHeaders() map[string]string {
+    if len(opts.Headers) == 0 {
+        return nil
+    }
+    redacted := make(map[string]string, len(opts.Headers))
+    for name, value := range opts.Headers {
+        lower := strings.ToLower(name)
+        for _, word := range sensitiveHeaderWords {
+            if strings.Contains(lower, word) {
+                value = "[redacted]"
+                break
+            }
+        }
+        redacted[name] = value
+    }
+    return redacted
+}
+
+// element is the name of the elements counted in the feed.
+func (opts FeedOptions) element() elementName {
+    s := opts.Element
+    if s == "" {
+        s = cfg().VacancyElement
+    }
+    n, _ := parseElementName(s)
+    return n
+}
+
+// parent is the element counted ones must be children of, empty if any.
+func (opts FeedOptions) parent() elementName {
+    s := opts.Parent
+    if s == "" {
+        s = cfg().VacancyParent
+    }
+    n, _ := parseElementName(s)
+    return n
+}
+
+// countingChanged tells if the options count the feed differently than
+// old ones. Tags, pinning, the timeout and the policies applied to a count
+// don't matter.
+func (opts FeedOptions) countingChanged(old FeedOptions) bool {
+    opts.Tags, old.Tags = nil, nil
+    opts.Pinned, old.Pinned = false, false
+    opts.Timeout, old.Timeout = nil, nil
+    opts.Validations, old.Validations = nil, nil
+    opts.TreatEmptyAsFailure, old.TreatEmptyAsFailure = nil, nil
+    opts.AbortOverBudget, old.AbortOverBudget = false, false
+    opts.Verify, old.Verify = false, false
+    return !reflect.DeepEqual(opts, old)
+}
+
+// root is the expected root element of the feed, empty if any is accepted.
+func (opts FeedOptions) root() elementName {
+    s := opts.Root
+    if s == "" {
+        s = cfg().RootElement
+    }
+    n, _ := parseElementName(s)
+    return n
+}
+
+// elementName matches elements by local name and, when Space is set, by
+// namespace too.
+type elementName xml.Name
+
+// parseElementName accepts "name" or "{namespace}name".
+func parseElementName(s string) (n elementName, err error) {
+    if strings.HasPrefix(s, "{") {
+        end := strings.Index(s, "}")
+        if end < 0 {
+            return n, fmt.Errorf("Invalid element name %q: unterminated namespace", s)
+        }
+        n.Space, s = s[1:end], s[end+1:]
+    }
+    if s == "" || strings.ContainsAny(s, " \t\r\n<>/{}:=!") {
+        return n, fmt.Errorf("Invalid element name %q", s)
+    }
+    n.Local = s
+    return n, nil
+}
+
+func (n elementName) matches(name xml.Name) bool {
+    return name.Local == n.Local && (n.Space == "" || name.Space == n.Space)
+}
+
+// attrRule decides by an attribute of its start element whether a vacancy
+// element is counted. The zero rule counts every one.
+type attrRule struct {
+    Name elementName
+    // Present requires the attribute, Absent forbids it, otherwise it's
+    // compared with Value, Excluded counting the others, a missing one too.
+    Present, Absent bool
+    Value           string
+    Excluded        bool
+}
+
+// parseAttrRule accepts "name" (present), "!name" (absent), "name=value"
+// and "name!=value", name may be "{namespace}name".
+func parseAttrRule(s string) (rule attrRule, err error) {
+    name := s
+    switch {
+    case strings.HasPrefix(s, "!"):
+        name, rule.Absent = s[1:], true
+    case strings.Contains(s, "!="):
+        i := strings.Index(s, "!=")
+        name, rule.Value, rule.Excluded = s[:i], s[i+2:], true
+    case strings.Contains(s, "="):
+        i := strings.Index(s, "=")
+        name, rule.Value = s[:i], s[i+1:]
+    default:
+        rule.Present = true
+    }
+    if rule.Name, err = parseElementName(name); err != nil {
+        return rule, fmt.Errorf("Invalid attribute rule %q: expected name, !name, name=value or name!=value", s)
+    }
+    return rule, nil
+}
+
+func (rule attrRule) matches(attrs []xml.Attr) bool {
+    if rule.Name.Local == "" {
+        return true
+    }
+    var value *string
+    for i := range attrs {
+        if rule.Name.matches(attrs[i].Name) {
+            value = &attrs[i].Value
+            break
+        }
+    }
+    switch {
+    case rule.Present:
+        return value != nil
+    case rule.Absent:
+        return value == nil
+    case rule.Excluded:
+        return value == nil || *value != rule.Value
+    default:
+        return value != nil && *value == rule.Value
+    }
+}
+
+// attr is the rule counted elements must pass, the zero one if none.
+func (opts FeedOptions) attr() attrRule {
+    s := opts.Attr
+    if s == "" {
+        s = cfg().VacancyAttr
+    }
+    if s == "" {
+        return attrRule{}
+    }
+    rule, _ := parseAttrRule(s)
+    return rule
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestAttrRules(t *testing.T) {
+    feed := `<vacancies xmlns:x="urn:x">
+  <vacancy status="active"/>
+  <vacancy status="archived"/>
+  <vacancy status="active" x:hot="1"/>
+  <vacancy/>
+</vacancies>`
+    tests := []struct {
+        rule  string
+        count int64
+    }{
+        {"", 4},
+        {"status", 3},
+        {"!status", 1},
+        {"status=active", 2},
+        {"status=archived", 1},
+        {"status=", 0},
+        {"status!=archived", 3},
+        {"status!=active", 2},
+        {"hot", 1},
+        {"{urn:x}hot=1", 1},
+        {"{urn:y}hot", 0},
+    }
+    for _, test := range tests {
+        if test.rule != "" {
+            if _, err := parseAttrRule(test.rule); err != nil {
+                t.Errorf("%q: %v", test.rule, err)
+                continue
+            }
+        }
+        cr, err := countArchive(strings.NewReader(feed), true, FeedOptions{Attr: test.rule}, nil)
+        if err != nil {
+            t.Errorf("%q: %v", test.rule, err)
+            continue
+        }
+        if cr.VacanciesCount != test.count || cr.RawCount != 4 {
+            t.Errorf("%q counted %d of %d, expected %d of 4", test.rule, cr.VacanciesCount, cr.RawCount, test.count)
+        }
+        // the raw count is served only when the rule excludes some
+        resp := newFeedInfoResponse("http://example.com/feed.xml.gz", FeedInfo{VacanciesCount: cr.VacanciesCount, RawCount: cr.RawCount})
+        switch raw := resp.RawVacanciesCount; {
+        case test.count == 4 && raw != nil:
+            t.Errorf("%q serves rawVacanciesCount %d with nothing excluded", test.rule, *raw)
+        case test.count != 4 && (raw == nil || *raw != 4):
+            t.Errorf("%q serves rawVacanciesCount %v, expected 4", test.rule, raw)
+        }
+    }
+}
+
+func TestParseAttrRuleRejects(t *testing.T) {
+    for _, rule := range []string{"!", "=active", "!=active", "sta tus", "{urn:x", "{urn:x}=1"} {
+        if _, err := parseAttrRule(rule); err == nil {
+            t.Errorf("%q is accepted", rule)
+        }
+    }
+}
+
+func TestCountingChanged(t *testing.T) {
+    yes := true
+    old := FeedOptions{Element: "vacancy", Tags: []string{"core"}}
+    tests := []struct {
+        name string
+        opts FeedOptions
+        want bool
+    }{
+        {"same", FeedOptions{Element: "vacancy", Tags: []string{"core"}}, false},
+        {"tags", FeedOptions{Element: "vacancy", Tags: []string{"reports"}}, false},
+        {"pinning", FeedOptions{Element: "vacancy", Pinned: true}, false},
+        {"timeout", FeedOptions{Element: "vacancy", Timeout: &Duration{time.Minute}}, false},
+        {"validations", FeedOptions{Element: "vacancy", Validations: map[string]checkPolicy{"frozen": policyOff}}, false},
+        {"empty as failure", FeedOptions{Element: "vacancy", TreatEmptyAsFailure: &yes}, false},
+        {"abort over budget", FeedOptions{Element: "vacancy", AbortOverBudget: true}, false},
+        {"verify", FeedOptions{Element: "vacancy", Verify: true}, false},
+        {"element", FeedOptions{Element: "job"}, true},
+        {"attr", FeedOptions{Element: "vacancy", Attr: "status=active"}, true},
+        {"headers", FeedOptions{Element: "vacancy", Headers: map[string]string{"X-Token": "a"}}, true},
+    }
+    for _, test := range tests {
+        if got := test.opts.countingChanged(old); got != test.want {
+            t.Errorf("%s: countingChanged = %v, expected %v", test.name, got, test.want)
+        }
+    }
+}
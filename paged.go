@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "flag"
+    "fmt"
+    "log"
+    "strings"
+)
+
+var maxFeedPages = flag.Int("max-feed-pages", 100, "most pages of a paged feed counted following their next links")
+
+// countPages counts a paged feed: url is its first page, each page links to
+// the next one with a Link rel=next header or in the page, their counts are
+// summed. Any page failing fails the count, the feed would be under-counted
+// otherwise. Links leading back to a counted page flag PageCycle and more
+// than -max-feed-pages pages flag PagesTruncated, the pages counted so far
+// are the count then.
+func countPages(ctx context.Context, url string, opts FeedOptions, progress func(count, downloaded int64)) (cr countResult, err error) {
+    // the stat size is of the whole feed, not of a page
+    ctx = withArchiveSize(ctx, 0)
+    hash := sha256.New()
+    seen := make(map[string]bool)
+    for page := url; page != ""; {
+        if cr.Pages >= max(*maxFeedPages, 1) {
+            log.Printf("%s has more than %d pages, %s and later aren't counted", url, *maxFeedPages, page)
+            cr.PagesTruncated = true
+            break
+        }
+        seen[page] = true
+        var report func(count, downloaded int64)
+        if progress != nil {
+            base, baseBytes := cr.VacanciesCount, cr.DownloadedBytes
+            report = func(count, downloaded int64) { progress(base+count, baseBytes+downloaded) }
+        }
+        pr, err := countPage(ctx, page, opts, report)
+        if err != nil {
+            return countResult{}, fmt.Errorf("Error counting page %d of %s: %w", cr.Pages+1, url, err)
+        }
+        cr.Pages++
+        if cr.Pages == 1 {
+            cr.ArchiveHost, cr.ContentLanguage, cr.GeneratedAt = pr.ArchiveHost, pr.ContentLanguage, pr.GeneratedAt
+        }
+        cr.VacanciesCount += pr.VacanciesCount
+        cr.RawCount += pr.RawCount
+        cr.DownloadedBytes += pr.DownloadedBytes
+        cr.Chunked = cr.Chunked || pr.Chunked
+        cr.Estimated = cr.Estimated || pr.Estimated
+        cr.TrailerCorrupt = cr.TrailerCorrupt || pr.TrailerCorrupt
+        cr.SizeBudgetExceeded = cr.SizeBudgetExceeded || pr.SizeBudgetExceeded
+        cr.PeakThroughput = max(cr.PeakThroughput, pr.PeakThroughput)
+        cr.AvgThroughput += pr.AvgThroughput
+        hash.Write([]byte(pr.ContentHash + "\n"))
+
+        page = ""
+        if pr.NextPage == "" {
+            break
+        }
+        next, err := canonicalURL(pr.NextPage)
+        if err != nil {
+            return countResult{}, fmt.Errorf("Error following next page of %s: %v", url, err)
+        }
+        if !cfg().hostAllowed(next) {
+            return countResult{}, fmt.Errorf("Error following next page of %s: host of %s isn't allowed", url, next)
+        }
+        if seen[next] {
+            log.Printf("%s pages make a cycle, page %d links back to %s", url, cr.Pages, next)
+            cr.PageCycle = true
+            break
+        }
+        page = next
+    }
+    cr.AvgThroughput /= float64(cr.Pages)
+    // an estimated page hashes nothing
+    if !cr.Estimated {
+        cr.ContentHash = hex.EncodeToString(hash.Sum(nil))
+    }
+    return cr, nil
+}
+
+// linkNext is the target of the rel="next" link of Link headers, empty if
+// there's none.
+func linkNext(headers []string) string {
+    for _, header := range headers {
+        for _, link := range strings.Split(header, ",") {
+            target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+            target = strings.TrimSpace(target)
+            if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+                continue
+            }
+            for _, param := range strings.Split(params, ";") {
+                name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+                if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+                    continue
+                }
+                for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+                    if strings.EqualFold(rel, "next") {
+                        return target[1 : len(target)-1]
+                    }
+                }
+            }
+        }
+    }
+    return ""
+}
+
+// xmlAttr is the value of the attribute with the local name, empty if it's
+// missing.
+func xmlAttr(attrs []xml.Attr, local string) string {
+    for _, a := range attrs {
+        if a.Name.Local == local {
+            return a.Value
+        }
+    }
+    return ""
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// checkPolicy is what a validation finding does to a check: "off" doesn't
+// look for it, "warn" flags the feed, "fail" fails the check.
+type checkPolicy string
+
+const (
+    policyOff  checkPolicy = "off"
+    policyWarn checkPolicy = "warn"
+    policyFail checkPolicy = "fail"
+)
+
+// Validation classes, each has its policy.
+const (
+    validationEmpty         = "empty"
+    validationContentType   = "content-type"
+    validationFrozen        = "frozen"
+    validationSizeBudget    = "size-budget"
+    validationRedirectHost  = "redirect-host"
+    validationCountMismatch = "count-mismatch"
+)
+
+var validationClasses = []string{
+    validationEmpty, validationContentType, validationFrozen,
+    validationSizeBudget, validationRedirectHost, validationCountMismatch,
+}
+
+// parsePolicies reads "class=policy,..." ("class:policy" too, it needs no
+// escaping in a query).
+func parsePolicies(s string) (map[string]checkPolicy, error) {
+    policies := make(map[string]checkPolicy)
+    for _, entry := range strings.Split(s, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        class, policy, ok := strings.Cut(entry, "=")
+        if !ok {
+            class, policy, ok = strings.Cut(entry, ":")
+        }
+        if !ok {
+            return nil, fmt.Errorf("Invalid validation policy %q: expected class=off|warn|fail", entry)
+        }
+        policies[strings.TrimSpace(class)] = checkPolicy(strings.TrimSpace(policy))
+    }
+    return policies, validatePolicies(policies)
+}
+
+func validatePolicies(policies map[string]checkPolicy) error {
+    for class, policy := range policies {
+        known := false
+        for _, c := range validationClasses {
+            known = known || c == class
+        }
+        if !known {
+            return fmt.Errorf("Unknown validation %q, expected one of %s", class, strings.Join(validationClasses, ", "))
+        }
+        switch policy {
+        case policyOff, policyWarn, policyFail:
+        default:
+            return fmt.Errorf("Invalid policy %q of %s validation: expected off, warn or fail", policy, class)
+        }
+    }
+    return nil
+}
+
+// policy is the policy of a validation of the feed: its own, then the
+// legacy options of the feed and the configured one. The defaults are the
+// behavior before policies: empty feeds by -treat-empty-as-failure,
+// redirects by -redirect-host-policy, content types fail, the rest warn.
+func (opts FeedOptions) policy(class string) checkPolicy {
+    if p, ok := opts.Validations[class]; ok {
+        return p
+    }
+    switch {
+    case class == validationEmpty && opts.TreatEmptyAsFailure != nil:
+        if *opts.TreatEmptyAsFailure {
+            return policyFail
+        }
+        return policyOff
+    case class == validationSizeBudget && opts.AbortOverBudget:
+        return policyFail
+    }
+    c := cfg()
+    if p, ok := c.Validations[class]; ok {
+        return p
+    }
+    switch class {
+    case validationEmpty:
+        if c.TreatEmptyAsFailure {
+            return policyFail
+        }
+        return policyOff
+    case validationRedirectHost:
+        return checkPolicy(c.RedirectHostPolicy)
+    case validationContentType:
+        return policyFail
+    default:
+        return policyWarn
+    }
+}
+
+// checkFindings collects the warnings of a check, the stat and the archive
+// may be validated deep in it.
+type checkFindings struct {
+    mu       sync.Mutex
+    warnings []string
+}
+
+type findingsKey struct{}
+
+func withFindings(ctx context.Context) (context.Context, *checkFindings) {
+    f := &checkFindings{}
+    return context.WithValue(ctx, findingsKey{}, f), f
+}
+
+// merge returns the classes warned about and those of warnings, sorted.
+func (f *checkFindings) merge(warnings []string) []string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    merged := append([]string(nil), warnings...)
+    for _, w := range f.warnings {
+        if !slices.Contains(merged, w) {
+            merged = append(merged, w)
+        }
+    }
+    if len(merged) == 0 {
+        return nil
+    }
+    sort.Strings(merged)
+    return merged
+}
+
+// validate applies the policy of class to a finding of a check of url: it
+// returns the finding if it fails the check, nil otherwise. A warning is
+// logged and recorded for the feed info.
+func validate(ctx context.Context, url string, opts FeedOptions, class string, finding error) error {
+    switch opts.policy(class) {
+    case policyFail:
+        return finding
+    case policyWarn:
+        log.Printf("%s: %v (%s warning)", url, finding, class)
+        if f, ok := ctx.Value(findingsKey{}).(*checkFindings); ok {
+            f.mu.Lock()
+            defer f.mu.Unlock()
+            if !slices.Contains(f.warnings, class) {
+                f.warnings = append(f.warnings, class)
+            }
+        }
+    }
+    return nil
+}
+
+// errFrozen fails a frozen feed with the "fail" frozen policy.
+var errFrozen = errors.New("feed content didn't change for -frozen-after")
+
+// countMismatchError is a verified feed counted differently twice.
+type countMismatchError struct {
+    Count, Verified int64
+}
+
+func (e *countMismatchError) Error() string {
+    return fmt.Sprintf("counts disagree: %d and %d vacancies", e.Count, e.Verified)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "flag"
+    "net/http"
+    "strings"
+)
+
+var (
+    pathPrefix = flag.String("path-prefix", "", "prefix of all routes for mounting behind a reverse proxy, e.g. /feed-monitor")
+    unprefixed = flag.String("unprefixed", "/readyz,/metrics", "comma separated routes also served without -path-prefix, e.g. for probes")
+)
+
+// withPathPrefix serves h under prefix, which is stripped before dispatch.
+// The unprefixed routes are served at their own paths too.
+func withPathPrefix(h http.Handler, prefix string, unprefixed []string) http.Handler {
+    prefix = "/" + strings.Trim(prefix, "/")
+    mux := http.NewServeMux()
+    mux.Handle(prefix+"/", http.StripPrefix(prefix, h))
+    for _, route := range unprefixed {
+        if route = strings.TrimSpace(route); route != "" {
+            mux.Handle(route, h)
+        }
+    }
+    return mux
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "log"
+)
+
+var feedsPath = flag.String("feeds", "", "JSON file with feeds monitored from the start: [{\"url\": ..., \"pinned\": true, options...}]")
+
+// preregisteredFeed is an entry of the -feeds file, options are as in the
+// registration query.
+type preregisteredFeed struct {
+    URL string `json:"url"`
+    FeedOptions
+}
+
+// loadPreregisteredFeeds reads and validates the -feeds file.
+func loadPreregisteredFeeds(path string) ([]preregisteredFeed, error) {
+    b, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    feeds, duplicates, err := parseFeedList(b)
+    if err != nil {
+        return nil, fmt.Errorf("Error in %s: %v", path, err)
+    }
+    for url, dups := range duplicates {
+        log.Printf("%s: %s is listed again as %q, the first entry is used", path, url, dups)
+    }
+    return feeds, nil
+}
+
+// parseFeedList reads and validates a JSON list of feeds with options, urls
+// are canonicalized. Entries of a feed listed already are dropped, the
+// urls they were listed with are returned by the canonical url.
+func parseFeedList(b []byte) (feeds []preregisteredFeed, duplicates map[string][]string, err error) {
+    var entries []preregisteredFeed
+    if err := json.Unmarshal(b, &entries); err != nil {
+        return nil, nil, err
+    }
+    seen := make(map[string]bool, len(entries))
+    for i, f := range entries {
+        raw := f.URL
+        if f.URL, err = canonicalURL(raw); err != nil {
+            return nil, nil, fmt.Errorf("feed %d: %v", i, err)
+        }
+        if err := f.FeedOptions.validate(); err != nil {
+            return nil, nil, fmt.Errorf("%s: %v", raw, err)
+        }
+        if seen[f.URL] {
+            if duplicates == nil {
+                duplicates = make(map[string][]string)
+            }
+            duplicates[f.URL] = append(duplicates[f.URL], raw)
+            continue
+        }
+        seen[f.URL] = true
+        feeds = append(feeds, f)
+    }
+    return feeds, duplicates, nil
+}
+
+// preregisterFeeds starts monitoring of feeds, the ones which can't be
+// registered now are retried every poll interval.
+func preregisterFeeds(feeds []preregisteredFeed) {
+    ticker := clock.NewTicker(cfg().PollInterval.Duration)
+    defer ticker.Stop()
+    for len(feeds) > 0 {
+        var retry []preregisteredFeed
+        for _, f := range feeds {
+            switch err := registerFeed(context.Background(), f.URL, f.FeedOptions); err {
+            case nil:
+                log.Printf("preregistered %s (pinned: %v)", f.URL, f.Pinned)
+            case errFeedsLimit:
+                log.Printf("Error preregistering %s: %v - skip it", f.URL, err)
+            default:
+                log.Printf("Error preregistering %s: %v - retry later", f.URL, err)
+                retry = append(retry, f)
+            }
+        }
+        feeds = retry
+        if len(feeds) > 0 {
+            <-ticker.C()
+        }
+    }
+}
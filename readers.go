@@ -0,0 +1,117 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "time"
+)
+
+var errSizeBudgetExceeded = errors.New("download size budget exceeded")
+
+// errEmptyFeed fails the count of a feed without vacancies when it's
+// treated as a failure.
+var errEmptyFeed = errors.New("feed has no vacancies")
+
+// errEmptyBody fails the count of an archive answered with no body.
+var errEmptyBody = errors.New("empty response body")
+
+// budgetReader counts bytes read from r. Once more than budget bytes are
+// read it marks Exceeded and, if abort is set, fails further reads.
+// Zero budget is unlimited.
+type budgetReader struct {
+    r        io.Reader
+    budget   int64
+    abort    bool
+    N        int64
+    Exceeded bool
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+    if br.Exceeded && br.abort {
+        return 0, errSizeBudgetExceeded
+    }
+    n, err := br.r.Read(p)
+    br.N += int64(n)
+    if br.budget > 0 && br.N > br.budget {
+        br.Exceeded = true
+        if br.abort {
+            return n, errSizeBudgetExceeded
+        }
+    }
+    return n, err
+}
+
+// ctxReader fails reads of r once ctx is done.
+type ctxReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+    if err := cr.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return cr.r.Read(p)
+}
+
+// errSampleDone ends reading of the sampled prefix of a feed.
+var errSampleDone = errors.New("sample read")
+
+// sampleReader reads up to n bytes from r, then fails with errSampleDone.
+type sampleReader struct {
+    r io.Reader
+    n int64
+}
+
+func (sr *sampleReader) Read(p []byte) (int, error) {
+    if sr.n <= 0 {
+        return 0, errSampleDone
+    }
+    if int64(len(p)) > sr.n {
+        p = p[:sr.n]
+    }
+    n, err := sr.r.Read(p)
+    sr.n -= int64(n)
+    return n, err
+}
+
+// throughputSampleBytes are read between throughput samples, so the clock
+// isn't checked on every read.
+const throughputSampleBytes = 1 << 20
+
+// throughputReader measures the rate of reading r in bytes per second: Peak
+// is the fastest of the samples, average is of the whole read.
+type throughputReader struct {
+    r     io.Reader
+    N     int64
+    Peak  float64
+    start time.Time
+    mark  time.Time
+    markN int64
+}
+
+func (tr *throughputReader) Read(p []byte) (int, error) {
+    if tr.start.IsZero() {
+        tr.start = time.Now()
+        tr.mark = tr.start
+    }
+    n, err := tr.r.Read(p)
+    tr.N += int64(n)
+    if tr.N-tr.markN >= throughputSampleBytes {
+        now := time.Now()
+        if d := now.Sub(tr.mark); d > 0 {
+            tr.Peak = max(tr.Peak, float64(tr.N-tr.markN)/d.Seconds())
+        }
+        tr.mark, tr.markN = now, tr.N
+    }
+    return n, err
+}
+
+// average is the rate since the first read.
+func (tr *throughputReader) average() float64 {
+    if d := time.Since(tr.start); !tr.start.IsZero() && d > 0 {
+        return float64(tr.N) / d.Seconds()
+    }
+    return 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "flag"
+    "time"
+)
+
+var reliabilityWindow = flag.Duration("reliability-window", 24*time.Hour, "window the success ratio of checks of a feed is computed over")
+
+// reliabilityBuckets the window is split into, checks older than the window
+// are dropped a bucket at a time.
+const reliabilityBuckets = 24
+
+type tallyBucket struct {
+    slot       int64
+    OK, Failed int64
+}
+
+// checkTally counts the checks of a feed over the recent -reliability-window,
+// so a feed failing every few checks shows even if it's never down for long.
+type checkTally struct {
+    buckets [reliabilityBuckets]tallyBucket
+}
+
+func tallySlot(now time.Time) int64 {
+    width := max(int64(*reliabilityWindow/reliabilityBuckets), 1)
+    return now.UnixNano() / width
+}
+
+func (t *checkTally) add(now time.Time, ok bool) {
+    slot := tallySlot(now)
+    b := &t.buckets[slot%reliabilityBuckets]
+    if b.slot != slot {
+        *b = tallyBucket{slot: slot}
+    }
+    if ok {
+        b.OK++
+    } else {
+        b.Failed++
+    }
+}
+
+// totals sums the buckets within the window.
+func (t *checkTally) totals(now time.Time) (ok, failed int64) {
+    slot := tallySlot(now)
+    for _, b := range t.buckets {
+        if b.slot <= slot && slot-b.slot < reliabilityBuckets {
+            ok += b.OK
+            failed += b.Failed
+        }
+    }
+    return ok, failed
+}
+
+// checkTallies are of monitored feeds, guarded by mu.
+var checkTallies = make(map[string]*checkTally)
+
+// recordCheck counts a check of url, mu must be held.
+func recordCheck(url string, ok bool, now time.Time) {
+    t, found := checkTallies[url]
+    if !found {
+        t = &checkTally{}
+        checkTallies[url] = t
+    }
+    t.add(now, ok)
+}
+
+// reliability is the percentage of successful checks of url within the
+// window, nil if there were none. mu must be held.
+func reliability(url string, now time.Time) (percent *float64, ok, failed int64) {
+    t, found := checkTallies[url]
+    if !found {
+        return nil, 0, 0
+    }
+    if ok, failed = t.totals(now); ok+failed == 0 {
+        return nil, 0, 0
+    }
+    p := 100 * float64(ok) / float64(ok+failed)
+    return &p, ok, failed
+}
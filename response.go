@@ -0,0 +1,193 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// wantsJSON tells if the client prefers JSON to the plain text responses.
+func wantsJSON(r *http.Request) bool {
+    return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+// apiError is a JSON error body, Code is stable for clients to check.
+type apiError struct {
+    Code     string   `json:"code"`
+    Message  string   `json:"message"`
+    Required []string `json:"required,omitempty"`
+}
+
+// writeError answers an error as JSON or plain text as the client prefers.
+func writeError(w http.ResponseWriter, r *http.Request, status int, e apiError) {
+    if wantsJSON(r) {
+        writeJSON(w, status, map[string]apiError{"error": e})
+        return
+    }
+    w.WriteHeader(status)
+    w.Write([]byte(e.Message + "\n"))
+}
+
+// feedInfoRequired are the parameters /feedinfo and /count require.
+var feedInfoRequired = []string{"url"}
+
+type feedInfoResponse struct {
+    URL            string     `json:"url"`
+    Status         string     `json:"status"`
+    RegisteredAt   *time.Time `json:"registeredAt,omitempty"`
+    // Stat may be cut and StatFields missing under -max-aux-memory pressure.
+    Stat           string     `json:"stat,omitempty"`
+    SizeText       string     `json:"sizeText,omitempty"`
+    SizeBytes      int64      `json:"sizeBytes,omitempty"`
+    VacanciesCount *int64     `json:"vacanciesCount"`
+    // RawVacanciesCount counts the elements the attribute rule excluded too,
+    // shown when some were.
+    RawVacanciesCount *int64 `json:"rawVacanciesCount,omitempty"`
+    // CountDelta is the change since the previous count, CountDeltaPerHour
+    // its rate, both unset before the second count.
+    CountDelta        *int64   `json:"countDelta,omitempty"`
+    CountDeltaPerHour *float64 `json:"countDeltaPerHour,omitempty"`
+    // Estimated marks VacanciesCount extrapolated from a sample.
+    Estimated      bool       `json:"estimated,omitempty"`
+    // LivenessOnly feeds have no VacanciesCount.
+    LivenessOnly   bool       `json:"livenessOnly,omitempty"`
+    TrailerCorrupt bool       `json:"trailerCorrupt,omitempty"`
+    GeneratedAt    *time.Time `json:"generatedAt,omitempty"`
+    UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+    CountedAt      *time.Time `json:"countedAt,omitempty"`
+    FailureSince   *time.Time `json:"failureSince,omitempty"`
+    FailureKind    string     `json:"failureKind,omitempty"`
+    Breaker        string     `json:"breaker,omitempty"`
+    RetryAt        *time.Time `json:"retryAt,omitempty"`
+    LastError      string     `json:"lastError,omitempty"`
+    LastErrorAt    *time.Time `json:"lastErrorAt,omitempty"`
+    // FailingFor is how long a failed feed served its last good info has
+    // been failing, in seconds.
+    FailingFor     int64      `json:"failingForSeconds,omitempty"`
+    Error          string     `json:"error,omitempty"`
+    Refreshing     bool       `json:"refreshing"`
+    Paused         bool       `json:"paused,omitempty"`
+    Stale          bool       `json:"stale,omitempty"`
+    PartialCount   int64      `json:"partialCount,omitempty"`
+    PartialBytes   int64      `json:"partialBytes,omitempty"`
+
+    DownloadedBytes    int64 `json:"downloadedBytes,omitempty"`
+    SizeBudgetExceeded bool  `json:"sizeBudgetExceeded,omitempty"`
+    Chunked            bool  `json:"chunked,omitempty"`
+
+    // throughputs of parsing the decompressed feed, bytes per second
+    AvgThroughput  float64 `json:"avgThroughput,omitempty"`
+    PeakThroughput float64 `json:"peakThroughput,omitempty"`
+
+    ContentHash    string     `json:"contentHash,omitempty"`
+    UnchangedSince *time.Time `json:"unchangedSince,omitempty"`
+    Frozen         bool       `json:"frozen,omitempty"`
+
+    ContentLanguage string `json:"contentLanguage,omitempty"`
+
+    CacheHits   int64 `json:"cacheHits"`
+    CacheMisses int64 `json:"cacheMisses"`
+
+    VerifiedCount *int64 `json:"verifiedCount,omitempty"`
+    CountMismatch bool   `json:"countMismatch,omitempty"`
+
+    // Parts are the counts of the parts of an index feed.
+    Parts       []indexPart `json:"parts,omitempty"`
+    PartsFailed int         `json:"partsFailed,omitempty"`
+
+    // Pages are counted of a paged feed.
+    Pages          int  `json:"pages,omitempty"`
+    PageCycle      bool `json:"pageCycle,omitempty"`
+    PagesTruncated bool `json:"pagesTruncated,omitempty"`
+
+    StatHost     string `json:"statHost,omitempty"`
+    ArchiveHost  string `json:"archiveHost,omitempty"`
+    HostMismatch bool   `json:"hostMismatch,omitempty"`
+
+    // Warnings are the validation classes which warned since the count.
+    Warnings []string `json:"warnings,omitempty"`
+
+    // StatFields are the "key:value" lines of the stat, StatCount is the
+    // vacancies count of a stat telling it.
+    StatFields map[string]string `json:"statFields,omitempty"`
+    StatCount  *int64            `json:"statCount,omitempty"`
+
+    // Failures are the recent failure episodes, with failures=true only.
+    Failures []failureEpisode `json:"failures,omitempty"`
+}
+
+func newFeedInfoResponse(url string, fi FeedInfo) feedInfoResponse {
+    resp := feedInfoResponse{
+        URL:            url,
+        Status:         fi.status(),
+        Stat:           fi.Stat,
+        SizeText:       fi.SizeText,
+        SizeBytes:      fi.SizeBytes,
+        VacanciesCount: &fi.VacanciesCount,
+        Estimated:      fi.Estimated,
+        TrailerCorrupt: fi.TrailerCorrupt,
+        GeneratedAt:    optionalTime(fi.GeneratedAt),
+        UpdatedAt:      optionalTime(fi.UpdatedAt),
+        CountedAt:      optionalTime(fi.CountedAt),
+        FailureSince:   optionalTime(fi.FailureSince),
+        FailureKind:    string(fi.FailureKind),
+        Breaker:        string(fi.Breaker),
+        RetryAt:        optionalTime(fi.RetryAt),
+        LastError:      fi.LastError,
+        LastErrorAt:    optionalTime(fi.LastErrorAt),
+        Refreshing:     fi.Refreshing,
+        Paused:         paused.Load(),
+        PartialCount:   fi.PartialCount,
+        PartialBytes:   fi.PartialBytes,
+
+        DownloadedBytes:    fi.DownloadedBytes,
+        SizeBudgetExceeded: fi.SizeBudgetExceeded,
+        Chunked:            fi.Chunked,
+
+        AvgThroughput:  fi.AvgThroughput,
+        PeakThroughput: fi.PeakThroughput,
+
+        ContentHash:    fi.ContentHash,
+        UnchangedSince: optionalTime(fi.UnchangedSince),
+        Frozen:         fi.frozen(),
+
+        ContentLanguage: fi.ContentLanguage,
+
+        CacheHits:   fi.CacheHits,
+        CacheMisses: fi.CacheMisses,
+
+        VerifiedCount: fi.VerifiedCount,
+        CountMismatch: fi.CountMismatch,
+
+        Parts:       fi.Parts,
+        PartsFailed: fi.PartsFailed,
+
+        Pages:          fi.Pages,
+        PageCycle:      fi.PageCycle,
+        PagesTruncated: fi.PagesTruncated,
+
+        StatHost:     fi.StatHost,
+        ArchiveHost:  fi.ArchiveHost,
+        HostMismatch: fi.HostMismatch,
+
+        Warnings: fi.Warnings,
+
+        StatFields: fi.StatFields,
+        StatCount:  fi.StatCount,
+    }
+    resp.CountDelta, resp.CountDeltaPerHour = fi.countDelta()
+    if fi.RawCount != fi.VacanciesCount {
+        resp.RawVacanciesCount = &fi.RawCount
+    }
+    if fi.LivenessOnly {
+        resp.VacanciesCount, resp.RawVacanciesCount, resp.LivenessOnly = nil, nil, true
+    }
+    return resp
+}
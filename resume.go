@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+)
+
+var maxResumes = flag.Int("max-resumes", 3, "how many times an interrupted archive download is resumed with a Range request, 0 - never")
+
+// resumeReader reads an archive response and, when the connection drops
+// mid-body, continues from the last byte read with a Range request, so the
+// parse above it goes on across the boundary.
+//
+// Resuming is only correct if the continuation is the same representation
+// byte by byte:
+//   - the server must announce Accept-Ranges: bytes,
+//   - the response must carry a strong ETag or a Last-Modified, sent back
+//     as If-Range so a changed archive is answered whole and the count fails
+//     instead of mixing two versions,
+//   - the response must not be Content-Encoded, the server compressing on
+//     the fly needn't produce the same bytes twice,
+//   - the continuation must be 206 starting exactly at the offset read.
+//
+// Otherwise the error is returned as is and the next check downloads the
+// archive again.
+type resumeReader struct {
+    ctx       context.Context
+    url       string
+    opts      FeedOptions
+    body      io.ReadCloser
+    validator string
+    n         int64
+    Resumes   int
+}
+
+// newResumeReader returns the body of res, resumable when it qualifies.
+func newResumeReader(ctx context.Context, url string, opts FeedOptions, res *http.Response) io.ReadCloser {
+    if *maxResumes <= 0 || res.StatusCode != http.StatusOK ||
+        res.Header.Get("Accept-Ranges") != "bytes" || res.Header.Get("Content-Encoding") != "" {
+        return res.Body
+    }
+    validator := res.Header.Get("ETag")
+    if strings.HasPrefix(validator, "W/") {
+        validator = ""
+    }
+    if validator == "" {
+        validator = res.Header.Get("Last-Modified")
+    }
+    if validator == "" {
+        return res.Body
+    }
+    return &resumeReader{ctx: ctx, url: url, opts: opts, body: res.Body, validator: validator}
+}
+
+func (rr *resumeReader) Read(p []byte) (int, error) {
+    n, err := rr.body.Read(p)
+    rr.n += int64(n)
+    if err == nil || err == io.EOF || rr.ctx.Err() != nil || rr.Resumes >= *maxResumes {
+        return n, err
+    }
+    if resumeErr := rr.resume(); resumeErr != nil {
+        log.Printf("Error resuming download of %s at %d bytes: %v", rr.url, rr.n, resumeErr)
+        return n, err
+    }
+    log.Printf("%s download interrupted at %d bytes (%v) - resumed", rr.url, rr.n, err)
+    return n, nil
+}
+
+var errNotResumed = errors.New("server didn't continue the archive")
+
+func (rr *resumeReader) resume() error {
+    req, err := newFeedRequest(rr.ctx, rr.url, rr.opts)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-", rr.n))
+    req.Header.Set("If-Range", rr.validator)
+    req.Header.Set("Accept-Encoding", "identity")
+    res, err := doFeedRequest(req)
+    if err != nil {
+        return err
+    }
+    if res.StatusCode != http.StatusPartialContent ||
+        !strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", rr.n)) {
+        res.Body.Close()
+        return fmt.Errorf("%w: %s, Content-Range %q", errNotResumed, res.Status, res.Header.Get("Content-Range"))
+    }
+    rr.body.Close()
+    rr.body = res.Body
+    rr.Resumes++
+    return nil
+}
+
+func (rr *resumeReader) Close() error {
+    return rr.body.Close()
+}
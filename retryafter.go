@@ -0,0 +1,51 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var maxRetryAfter = flag.Duration("max-retry-after", time.Hour, "longest Retry-After of a 429 or 503 from a feed server the next check waits for, 0 - ignore Retry-After")
+
+// retryAfter is when a server answering 429 or 503 asks to be requested
+// again, zero if it doesn't tell. Retry-After is either delta-seconds or an
+// HTTP-date, a later one is cut to -max-retry-after.
+func retryAfter(res *http.Response) time.Time {
+    if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable || *maxRetryAfter <= 0 {
+        return time.Time{}
+    }
+    v := strings.TrimSpace(res.Header.Get("Retry-After"))
+    if v == "" {
+        return time.Time{}
+    }
+    now := clock.Now()
+    var at time.Time
+    if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+        if seconds < 0 {
+            return time.Time{}
+        }
+        at = now.Add(time.Duration(min(seconds, int64(*maxRetryAfter/time.Second)+1)) * time.Second)
+    } else if at, err = http.ParseTime(v); err != nil {
+        return time.Time{}
+    }
+    if !at.After(now) {
+        return time.Time{}
+    }
+    if limit := now.Add(*maxRetryAfter); at.After(limit) {
+        return limit
+    }
+    return at
+}
+
+// retryAfterOf is the Retry-After time of a failed check, zero if none.
+func retryAfterOf(err error) time.Time {
+    var se *statusError
+    if errors.As(err, &se) {
+        return se.RetryAfter
+    }
+    return time.Time{}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "net/http"
+    "strconv"
+    "sync"
+    "sync/atomic"
+)
+
+var maxActiveChecks = flag.Int("max-active-checks", 0, "maximum feeds checked at once, others wait their turn; 0 - unlimited")
+
+// checkSlots admits feed checks to run, nil when unlimited. A feed holds a
+// slot for a single check only, waiting feeds get slots in the order they
+// asked for them, so every monitored feed is checked in its turn. The first
+// counts of feeds go first, nothing is known about them until then.
+var (
+    checkSlots     *slots
+    checkSlotsOnce sync.Once
+)
+
+// activeChecks and queuedChecks are the checks running and waiting for a
+// slot at the moment, queuedFirstCounts - the waiting first counts of them.
+var activeChecks, queuedChecks, queuedFirstCounts atomic.Int64
+
+type firstCountContextKey struct{}
+
+// withFirstCount marks ctx of the first count of a feed, it's admitted
+// before recounts.
+func withFirstCount(ctx context.Context) context.Context {
+    return context.WithValue(ctx, firstCountContextKey{}, true)
+}
+
+func isFirstCount(ctx context.Context) bool {
+    first, _ := ctx.Value(firstCountContextKey{}).(bool)
+    return first
+}
+
+// slots is a semaphore which grants slots to waiters in order, first counts
+// before others.
+type slots struct {
+    mu   sync.Mutex
+    free int
+    // waiting are the waiters of first counts and of others
+    waiting [2][]chan struct{}
+}
+
+func newSlots(n int) *slots {
+    return &slots{free: n}
+}
+
+// acquire waits for a slot, first count waiters are granted theirs first.
+func (s *slots) acquire(ctx context.Context, first bool) error {
+    s.mu.Lock()
+    if s.free > 0 && len(s.waiting[0])+len(s.waiting[1]) == 0 {
+        s.free--
+        s.mu.Unlock()
+        return nil
+    }
+    lane := 1
+    if first {
+        lane = 0
+    }
+    granted := make(chan struct{})
+    s.waiting[lane] = append(s.waiting[lane], granted)
+    s.mu.Unlock()
+    select {
+    case <-granted:
+        return nil
+    case <-ctx.Done():
+        s.mu.Lock()
+        removed := false
+        for i, ch := range s.waiting[lane] {
+            if ch == granted {
+                s.waiting[lane] = append(s.waiting[lane][:i], s.waiting[lane][i+1:]...)
+                removed = true
+                break
+            }
+        }
+        s.mu.Unlock()
+        if !removed {
+            // granted meanwhile
+            s.release()
+        }
+        return ctx.Err()
+    }
+}
+
+// release passes the slot to the next waiter.
+func (s *slots) release() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for lane := range s.waiting {
+        if len(s.waiting[lane]) > 0 {
+            close(s.waiting[lane][0])
+            s.waiting[lane] = s.waiting[lane][1:]
+            return
+        }
+    }
+    s.free++
+}
+
+// admitCheck waits for a check slot, the returned func releases it.
+func admitCheck(ctx context.Context) (release func(), err error) {
+    checkSlotsOnce.Do(func() {
+        if *maxActiveChecks > 0 {
+            checkSlots = newSlots(*maxActiveChecks)
+        }
+    })
+    done := func() { activeChecks.Add(-1) }
+    if checkSlots == nil {
+        activeChecks.Add(1)
+        return done, nil
+    }
+    first := isFirstCount(ctx)
+    queuedChecks.Add(1)
+    if first {
+        queuedFirstCounts.Add(1)
+        defer queuedFirstCounts.Add(-1)
+    }
+    err = checkSlots.acquire(ctx, first)
+    queuedChecks.Add(-1)
+    if err != nil {
+        return nil, err
+    }
+    activeChecks.Add(1)
+    return func() {
+        done()
+        checkSlots.release()
+    }, nil
+}
+
+// setQueueDepth tells a client waiting for a first count how many first
+// counts are queued, so it can poll less often when the queue is long.
+func setQueueDepth(w http.ResponseWriter) {
+    w.Header().Set("X-Queue-Depth", strconv.FormatInt(queuedFirstCounts.Load(), 10))
+}
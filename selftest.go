@@ -0,0 +1,63 @@
+package main
+
+import (
+    "bytes"
+    _ "embed"
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// selftestFeed is a gzipped feed of two members with selftestCount vacancies
+// directly inside the root and a few more nested in them, which aren't
+// counted.
+//
+//go:embed selftest/feed.xml.gz
+var selftestFeed []byte
+
+const selftestCount = 25
+
+// selftestOptions are explicit, so the configured element, parent and
+// attribute rule don't change what the fixture counts.
+var selftestOptions = FeedOptions{Element: "vacancy", Parent: "vacancies", Root: "vacancies", Attr: "id"}
+
+type selftestResponse struct {
+    Passed   bool    `json:"passed"`
+    Count    int64   `json:"count"`
+    Expected int64   `json:"expected"`
+    Error    string  `json:"error,omitempty"`
+    Duration float64 `json:"durationSeconds"`
+}
+
+// selftestHandler serves GET /admin/selftest counting the embedded feed the
+// way monitored feeds are counted, it answers 500 if the count is wrong. A
+// failing self-test means the parser broke, not the upstream feeds.
+func selftestHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", http.MethodGet)
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+    started := time.Now()
+    cr, err := countArchive(bytes.NewReader(selftestFeed), false, selftestOptions, nil)
+    resp := selftestResponse{Count: cr.VacanciesCount, Expected: selftestCount, Duration: time.Since(started).Seconds()}
+    if err == nil && cr.VacanciesCount != selftestCount {
+        err = fmt.Errorf("counted %d vacancies, expected %d", cr.VacanciesCount, selftestCount)
+    }
+    status := http.StatusOK
+    resp.Passed = err == nil
+    if err != nil {
+        resp.Error = err.Error()
+        status = http.StatusInternalServerError
+    }
+    if wantsJSON(r) {
+        writeJSON(w, status, resp)
+        return
+    }
+    w.WriteHeader(status)
+    if resp.Passed {
+        w.Write([]byte(fmt.Sprintf("passed: %d vacancies\n", resp.Count)))
+        return
+    }
+    w.Write([]byte(fmt.Sprintf("FAILED: %s\n", resp.Error)))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+const defaultSizePattern = `size:\s*(\d+(?:\.\d+)?\s*(?:bytes|[KMGT]i?B|B)?)`
+
+var sizePattern = flag.String("size-pattern", envOr("FEED_SIZE_PATTERN", defaultSizePattern),
+    "regexp finding the archive size in a stat, the first group is the size with an optional unit (env FEED_SIZE_PATTERN)")
+
+// sizeRe finds the archive size in a stat, like "size:123 bytes" or
+// "size:1.2 GB". The first group is the size with an optional unit.
+var sizeRe = regexp.MustCompile(defaultSizePattern)
+
+// compileSizePattern validates -size-pattern and makes it sizeRe.
+func compileSizePattern(pattern string) error {
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        return fmt.Errorf("Invalid size pattern: %v", err)
+    }
+    if re.NumSubexp() < 1 {
+        return fmt.Errorf("Invalid size pattern %q: no group capturing the size", pattern)
+    }
+    sizeRe = re
+    return nil
+}
+
+func envOr(name, value string) string {
+    if v, ok := os.LookupEnv(name); ok {
+        return v
+    }
+    return value
+}
+
+// feedSize is the archive size as written in the stat and in bytes. Change
+// detection compares Bytes, so "1024 bytes" and "1 KB" are the same size.
+// A probed archive may have just an ETag.
+type feedSize struct {
+    Text  string
+    Bytes int64
+    ETag  string
+}
+
+type archiveSizeKey struct{}
+
+// withArchiveSize tells the counts of ctx the archive size the stat reports,
+// 0 - unknown.
+func withArchiveSize(ctx context.Context, bytes int64) context.Context {
+    return context.WithValue(ctx, archiveSizeKey{}, bytes)
+}
+
+// archiveSize is the size a response without Content-Length, e.g. a chunked
+// one, is taken to have.
+func archiveSize(ctx context.Context) int64 {
+    bytes, _ := ctx.Value(archiveSizeKey{}).(int64)
+    return bytes
+}
+
+var sizeUnits = map[string]float64{
+    "":      1,
+    "B":     1,
+    "bytes": 1,
+    "KB":    1 << 10,
+    "KiB":   1 << 10,
+    "MB":    1 << 20,
+    "MiB":   1 << 20,
+    "GB":    1 << 30,
+    "GiB":   1 << 30,
+    "TB":    1 << 40,
+    "TiB":   1 << 40,
+}
+
+// parseSize converts "123", "123 bytes" or "1.2 GB" to bytes, units are
+// binary.
+func parseSize(s string) (int64, error) {
+    s = strings.TrimSpace(s)
+    i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+    number, unit := s, ""
+    if i >= 0 {
+        number, unit = s[:i], strings.TrimSpace(s[i:])
+    }
+    multiplier, ok := sizeUnits[unit]
+    if !ok {
+        return 0, fmt.Errorf("unknown size unit %q", unit)
+    }
+    v, err := strconv.ParseFloat(number, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid size %q", s)
+    }
+    return int64(v * multiplier), nil
+}
+
+// extractSize finds the archive size in a stat body.
+func extractSize(stat []byte) (size feedSize, err error) {
+    m := sizeRe.FindSubmatch(stat)
+    if m == nil {
+        return size, fmt.Errorf("no size in stat")
+    }
+    size.Text = string(m[0])
+    size.Bytes, err = parseSize(string(m[1]))
+    return size, err
+}
+
+// parseStatFields splits a stat into its "key:value" lines, lines without
+// a colon are skipped. The size keeps being found by sizeRe.
+func parseStatFields(stat []byte) map[string]string {
+    fields := make(map[string]string)
+    for _, line := range strings.Split(string(stat), "\n") {
+        key, value, ok := strings.Cut(line, ":")
+        key = strings.TrimSpace(key)
+        if ok && key != "" {
+            fields[key] = strings.TrimSpace(value)
+        }
+    }
+    return fields
+}
+
+// statCountKeys are the stat fields recognized as the vacancies count.
+var statCountKeys = []string{"count", "vacancies"}
+
+// statCount is the vacancies count the stat tells, nil if it doesn't.
+func statCount(fields map[string]string) *int64 {
+    for _, key := range statCountKeys {
+        for k, v := range fields {
+            if strings.EqualFold(k, key) {
+                if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+                    return &n
+                }
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io/fs"
+    "io/ioutil"
+    "log"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "sort"
+    "syscall"
+    "time"
+)
+
+var (
+    statePath     = flag.String("state-file", "", "file the monitored feeds with their options and counts are saved to, and restored from at start; empty - none")
+    stateInterval = flag.Duration("state-interval", time.Minute, "how often -state-file is saved")
+)
+
+// stateVersion is the format of -state-file, it's bumped when a change
+// needs older files migrated.
+const stateVersion = 1
+
+type savedState struct {
+    Version int       `json:"version"`
+    SavedAt time.Time `json:"savedAt"`
+    // PausedAt is the start of the pause of monitoring, nil if it isn't
+    // paused.
+    PausedAt *time.Time  `json:"pausedAt,omitempty"`
+    Feeds    []savedFeed `json:"feeds"`
+}
+
+// savedFeed is a monitored feed with the options it was registered with,
+// Info is its last info, nil before the first count.
+type savedFeed struct {
+    URL          string      `json:"url"`
+    RegisteredAt time.Time   `json:"registeredAt"`
+    Options      FeedOptions `json:"options"`
+    Info         *FeedInfo   `json:"info,omitempty"`
+}
+
+// snapshotState copies the monitored feeds, sorted by url.
+func snapshotState() savedState {
+    mu.RLock()
+    defer mu.RUnlock()
+    st := savedState{Version: stateVersion, SavedAt: clock.Now(), Feeds: make([]savedFeed, 0, len(updaters))}
+    if paused.Load() {
+        st.PausedAt = optionalTime(pausedAt)
+    }
+    for url := range updaters {
+        f := savedFeed{URL: url, RegisteredAt: registered[url], Options: options[url]}
+        if fi, ok := info[url]; ok {
+            // a recount in progress doesn't survive a restart
+            fi.Refreshing, fi.PartialCount, fi.PartialBytes = false, 0, 0
+            f.Info = &fi
+        }
+        st.Feeds = append(st.Feeds, f)
+    }
+    sort.Slice(st.Feeds, func(i, j int) bool { return st.Feeds[i].URL < st.Feeds[j].URL })
+    return st
+}
+
+// saveState writes the state to path through a temporary file, so a crash
+// leaves the previous one.
+func saveState(path string, st savedState) error {
+    b, err := json.MarshalIndent(st, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+    if err != nil {
+        return err
+    }
+    if _, err := tmp.Write(b); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// loadState reads the state saved to path, a missing file is an empty
+// state. Options are validated and urls canonicalized as in a -feeds file.
+func loadState(path string) (st savedState, err error) {
+    b, err := ioutil.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return savedState{Version: stateVersion}, nil
+    }
+    if err != nil {
+        return st, err
+    }
+    if err := json.Unmarshal(b, &st); err != nil {
+        return st, fmt.Errorf("Error in %s: %v", path, err)
+    }
+    if err := migrateState(&st); err != nil {
+        return st, fmt.Errorf("Error in %s: %v", path, err)
+    }
+    for i := range st.Feeds {
+        f := &st.Feeds[i]
+        raw := f.URL
+        if f.URL, err = canonicalURL(raw); err != nil {
+            return st, fmt.Errorf("Error in %s: feed %d: %v", path, i, err)
+        }
+        if err := f.Options.validate(); err != nil {
+            return st, fmt.Errorf("Error in %s: %s: %v", path, raw, err)
+        }
+    }
+    return st, nil
+}
+
+// migrateState upgrades a state saved by an older version to stateVersion.
+// Options added since are missing in older files and take their defaults,
+// only renamed or reinterpreted ones need a step here.
+func migrateState(st *savedState) error {
+    switch {
+    case st.Version == stateVersion:
+        return nil
+    case st.Version > stateVersion:
+        return fmt.Errorf("state version %d is newer than %d of this build", st.Version, stateVersion)
+    default:
+        return fmt.Errorf("unknown state version %d", st.Version)
+    }
+}
+
+// restorePause pauses monitoring if it was paused when the state was
+// saved, the pause keeps its start.
+func restorePause(st savedState) {
+    if st.PausedAt == nil {
+        return
+    }
+    mu.Lock()
+    paused.Store(true)
+    pausedAt = *st.PausedAt
+    mu.Unlock()
+    log.Printf("monitoring is paused since %s as saved", pausedAt.Format(time.RFC3339))
+}
+
+// restoreFeeds starts monitoring of the saved feeds with their options.
+// The saved info is in place before the first check, so a feed which size
+// didn't change isn't recounted. Feeds which can't be registered now are
+// retried every poll interval as preregistered ones.
+func restoreFeeds(feeds []savedFeed) {
+    ticker := clock.NewTicker(cfg().PollInterval.Duration)
+    defer ticker.Stop()
+    for len(feeds) > 0 {
+        var retry []savedFeed
+        for _, f := range feeds {
+            mu.Lock()
+            if _, counted := info[f.URL]; !counted && f.Info != nil {
+                info[f.URL] = *f.Info
+            }
+            mu.Unlock()
+            err := registerFeed(context.Background(), f.URL, f.Options)
+            mu.Lock()
+            if err == nil && !f.RegisteredAt.IsZero() {
+                registered[f.URL] = f.RegisteredAt
+            }
+            if _, monitored := updaters[f.URL]; !monitored {
+                delete(info, f.URL)
+            }
+            mu.Unlock()
+            switch err {
+            case nil:
+                log.Printf("restored %s", f.URL)
+            case errFeedsLimit:
+                log.Printf("Error restoring %s: %v - skip it", f.URL, err)
+            default:
+                log.Printf("Error restoring %s: %v - retry later", f.URL, err)
+                retry = append(retry, f)
+            }
+        }
+        feeds = retry
+        if len(feeds) > 0 {
+            <-ticker.C()
+        }
+    }
+}
+
+// saveStatePeriodically saves the state to path every -state-interval and
+// once more on SIGTERM or SIGINT before exiting.
+func saveStatePeriodically(path string) {
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+    ticker := clock.NewTicker(*stateInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C():
+            if err := saveState(path, snapshotState()); err != nil {
+                log.Printf("Error saving state to %s: %v", path, err)
+            }
+        case sig := <-stop:
+            st := snapshotState()
+            if err := saveState(path, st); err != nil {
+                log.Fatalf("Error saving state to %s on %v: %v", path, sig, err)
+            }
+            log.Printf("saved %d feeds to %s on %v", len(st.Feeds), path, sig)
+            os.Exit(0)
+        }
+    }
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "net/http/httptest"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestPauseIsSaved(t *testing.T) {
+    t.Cleanup(func() { paused.Store(false) })
+    since := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+    mu.Lock()
+    paused.Store(true)
+    pausedAt = since
+    mu.Unlock()
+    path := filepath.Join(t.TempDir(), "state.json")
+    if err := saveState(path, snapshotState()); err != nil {
+        t.Fatal(err)
+    }
+
+    paused.Store(false)
+    st, err := loadState(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    restorePause(st)
+    mu.RLock()
+    defer mu.RUnlock()
+    if !paused.Load() || !pausedAt.Equal(since) {
+        t.Errorf("restored paused %v since %v, expected paused since %v", paused.Load(), pausedAt, since)
+    }
+}
+
+func TestStateRoundTrip(t *testing.T) {
+    forgetFeedsAfter(t)
+    archive := gzipped(t, vacanciesXML(2))
+    srv := httptest.NewServer(feedHandler(archive))
+    defer srv.Close()
+    url := srv.URL + "/feed.xml.gz"
+    opts := FeedOptions{
+        Element: "job",
+        Root:    "jobs",
+        Verify:  true,
+        Timeout: &Duration{5 * time.Second},
+        Headers: map[string]string{"X-Token": "secret"},
+    }
+    at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+    // the saved count differs from the archive one, so a recount would show
+    saved := FeedInfo{
+        Stat:           fmt.Sprintf("size:%d bytes", len(archive)),
+        SizeText:       fmt.Sprintf("%d bytes", len(archive)),
+        SizeBytes:      int64(len(archive)),
+        VacanciesCount: 42,
+        CountedAt:      at,
+        UpdatedAt:      at,
+    }
+    monitorForTest(t, url, opts)
+    mu.Lock()
+    info[url], registered[url] = saved, at
+    mu.Unlock()
+    path := filepath.Join(t.TempDir(), "state.json")
+    if err := saveState(path, snapshotState()); err != nil {
+        t.Fatal(err)
+    }
+
+    mu.Lock()
+    forgetFeed(url)
+    mu.Unlock()
+    st, err := loadState(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(st.Feeds) != 1 {
+        t.Fatalf("loaded %d feeds, expected 1", len(st.Feeds))
+    }
+    if f := st.Feeds[0]; f.Info == nil || !reflect.DeepEqual(*f.Info, saved) {
+        t.Errorf("loaded info %+v, expected %+v", f.Info, saved)
+    }
+
+    restoreFeeds(st.Feeds)
+    mu.RLock()
+    defer mu.RUnlock()
+    if !reflect.DeepEqual(options[url], opts) {
+        t.Errorf("restored options %+v, expected %+v", options[url], opts)
+    }
+    if fi := info[url]; fi.VacanciesCount != saved.VacanciesCount || fi.SizeBytes != saved.SizeBytes || !fi.CountedAt.Equal(at) {
+        t.Errorf("restored info %+v, expected %+v", fi, saved)
+    }
+    if !registered[url].Equal(at) {
+        t.Errorf("restored registration time %v, expected %v", registered[url], at)
+    }
+}
+
+func TestLoadStateVersions(t *testing.T) {
+    dir := t.TempDir()
+    tests := []struct {
+        version int
+        err     string
+    }{
+        {stateVersion, ""},
+        {stateVersion + 1, "is newer than"},
+        {0, "unknown state version 0"},
+    }
+    for _, test := range tests {
+        path := filepath.Join(dir, fmt.Sprintf("state-%d.json", test.version))
+        body := fmt.Sprintf(`{"version":%d,"feeds":[{"url":"http://example.com/feed.xml.gz"}]}`, test.version)
+        if err := ioutil.WriteFile(path, []byte(body), 0o644); err != nil {
+            t.Fatal(err)
+        }
+        st, err := loadState(path)
+        switch {
+        case test.err == "" && err != nil:
+            t.Errorf("version %d: %v", test.version, err)
+        case test.err == "" && len(st.Feeds) != 1:
+            t.Errorf("version %d: loaded %d feeds, expected 1", test.version, len(st.Feeds))
+        case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+            t.Errorf("version %d: got error %v, expected one with %q", test.version, err, test.err)
+        }
+    }
+
+    st, err := loadState(filepath.Join(dir, "missing.json"))
+    if err != nil || st.Version != stateVersion || len(st.Feeds) != 0 {
+        t.Errorf("missing file loaded %+v, %v, expected an empty state", st, err)
+    }
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "net/http"
+    "runtime"
+    "sync/atomic"
+)
+
+// activePolls is the number of running monitorFeed goroutines. It should be
+// equal to the number of monitored feeds, a bigger one means leaking pollers.
+var activePolls atomic.Int64
+
+// cacheHits are checks which skipped the recount as the size didn't change,
+// cacheMisses - recounts, recountsPostponed - changes waiting for
+// -min-recount-interval.
+var cacheHits, cacheMisses, recountsPostponed atomic.Int64
+
+// totalChecks are the checks of every feed, failedChecks - the failed ones,
+// downloadedBytes - the archive bytes read by counts. They are updated by
+// every monitoring goroutine, atomics keep them off mu.
+var totalChecks, failedChecks, downloadedBytes atomic.Int64
+
+type stats struct {
+    Goroutines     int   `json:"goroutines"`
+    ActivePolls    int64 `json:"activePolls"`
+    MonitoredFeeds int   `json:"monitoredFeeds"`
+    CountedFeeds   int   `json:"countedFeeds"`
+    Paused         bool  `json:"paused"`
+    // ActiveChecks run now, QueuedChecks wait for -max-active-checks.
+    ActiveChecks int64 `json:"activeChecks"`
+    QueuedChecks int64 `json:"queuedChecks"`
+    // QueuedFirstCounts are the queued checks of feeds not counted yet.
+    QueuedFirstCounts int64 `json:"queuedFirstCounts"`
+    // Updaters and InfoEntries are the sizes of the feed maps regardless of
+    // the tag filter, Headroom is how many more feeds FeedsLimit allows. A
+    // lasting gap between the sizes is feeds never counted or stale info.
+    Updaters    int `json:"updaters"`
+    InfoEntries int `json:"infoEntries"`
+    FeedsLimit  int `json:"feedsLimit"`
+    Headroom    int `json:"headroom"`
+
+    CacheHits         int64   `json:"cacheHits"`
+    CacheMisses       int64   `json:"cacheMisses"`
+    RecountsPostponed int64   `json:"recountsPostponed"`
+    CacheHitRatio     float64 `json:"cacheHitRatio"`
+
+    Checks          int64 `json:"checks"`
+    FailedChecks    int64 `json:"failedChecks"`
+    DownloadedBytes int64 `json:"downloadedBytes"`
+
+    // AuxMemoryBytes estimates the failure episodes, duration windows and
+    // raw stats kept, AuxMemoryLimit is -max-aux-memory, AuxMemoryTrimmed -
+    // the entries trimmed to it.
+    AuxMemoryBytes   int64 `json:"auxMemoryBytes"`
+    AuxMemoryLimit   int64 `json:"auxMemoryLimit"`
+    AuxMemoryTrimmed int64 `json:"auxMemoryTrimmed"`
+}
+
+// statsHandler serves /stats, with ?tag= the feed counts are of the tagged
+// feeds only.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+    filter, err := parseTagFilter(r.URL.Query())
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(err.Error()))
+        return
+    }
+    var s stats
+    mu.RLock()
+    s.Updaters, s.InfoEntries, s.FeedsLimit, s.Headroom = mapSizes()
+    s.AuxMemoryBytes = auxMemory()
+    for url := range updaters {
+        if !filter.matches(options[url].Tags) {
+            continue
+        }
+        s.MonitoredFeeds++
+        if _, ok := info[url]; ok {
+            s.CountedFeeds++
+        }
+    }
+    mu.RUnlock()
+    s.Goroutines = runtime.NumGoroutine()
+    s.ActivePolls = activePolls.Load()
+    s.Paused = paused.Load()
+    s.ActiveChecks = activeChecks.Load()
+    s.QueuedChecks = queuedChecks.Load()
+    s.QueuedFirstCounts = queuedFirstCounts.Load()
+    s.CacheHits, s.CacheMisses = cacheHits.Load(), cacheMisses.Load()
+    s.RecountsPostponed = recountsPostponed.Load()
+    s.Checks, s.FailedChecks = totalChecks.Load(), failedChecks.Load()
+    s.AuxMemoryLimit, s.AuxMemoryTrimmed = *maxAuxMemory, auxTrimmed.Load()
+    s.DownloadedBytes = downloadedBytes.Load()
+    if total := s.CacheHits + s.CacheMisses; total > 0 {
+        s.CacheHitRatio = float64(s.CacheHits) / float64(total)
+    }
+    writeJSON(w, http.StatusOK, s)
+}
+
+// mapSizes returns the sizes of updaters and info, the feeds limit and the
+// headroom left, mu must be held.
+func mapSizes() (updatersLen, infoLen, limit, headroom int) {
+    return len(updaters), len(info), FeedsLimit, max(FeedsLimit-len(updaters), 0)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// tagFilter selects feeds by their registration tags. An empty filter
+// selects every feed.
+type tagFilter struct {
+    Tags []string
+    // All requires every tag, otherwise any of them is enough.
+    All bool
+}
+
+// parseTagFilter reads ?tag=a&tag=b&match=any|all.
+func parseTagFilter(values url.Values) (f tagFilter, err error) {
+    f.Tags = values["tag"]
+    switch m := values.Get("match"); m {
+    case "", "any":
+    case "all":
+        f.All = true
+    default:
+        return f, fmt.Errorf("Invalid match %q: expected any or all", m)
+    }
+    return f, nil
+}
+
+func (f tagFilter) matches(tags []string) bool {
+    if len(f.Tags) == 0 {
+        return true
+    }
+    for _, want := range f.Tags {
+        found := false
+        for _, tag := range tags {
+            if tag == want {
+                found = true
+                break
+            }
+        }
+        if found != f.All {
+            return found
+        }
+    }
+    return f.All
+}
+
+// validateTag accepts any non-empty tag without whitespace.
+func validateTag(tag string) error {
+    if tag == "" || strings.IndexFunc(tag, func(r rune) bool { return r <= ' ' }) >= 0 {
+        return fmt.Errorf("Invalid tag %q", tag)
+    }
+    return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var otelEndpoint = flag.String("otel-endpoint", "", "OTLP/HTTP collector to export check traces to, e.g. http://localhost:4318")
+
+// tracer is nil unless -otel-endpoint is set, so spans cost nothing by default.
+var tracer *otlpExporter
+
+type spanContext struct {
+    TraceID [16]byte
+    SpanID  [8]byte
+}
+
+type spanContextKey struct{}
+
+type spanAttr struct {
+    Key   string
+    Value interface{}
+}
+
+type span struct {
+    sc       spanContext
+    parentID [8]byte
+    name     string
+    start    time.Time
+    end      time.Time
+    attrs    []spanAttr
+    err      error
+}
+
+// startSpan starts a child of the span in ctx, or a new trace if there is
+// none. It returns a nil span when tracing is disabled, all span methods are
+// safe to call on it.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+    if tracer == nil {
+        return ctx, nil
+    }
+    s := &span{name: name, start: time.Now()}
+    if parent, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+        s.sc.TraceID = parent.TraceID
+        s.parentID = parent.SpanID
+    } else {
+        rand.Read(s.sc.TraceID[:])
+    }
+    rand.Read(s.sc.SpanID[:])
+    return context.WithValue(ctx, spanContextKey{}, s.sc), s
+}
+
+func (s *span) setAttr(key string, value interface{}) {
+    if s == nil {
+        return
+    }
+    s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+// traceID is the hex trace id, empty for a nil span.
+func (s *span) traceID() string {
+    if s == nil {
+        return ""
+    }
+    return hex.EncodeToString(s.sc.TraceID[:])
+}
+
+func (s *span) finish(err error) {
+    if s == nil {
+        return
+    }
+    s.end = time.Now()
+    s.err = err
+    tracer.export(s)
+}
+
+// traceContextFromRequest keeps the W3C trace context of an incoming request
+// so that checks it triggers continue the caller's trace. The returned
+// context isn't bound to the request lifetime.
+func traceContextFromRequest(r *http.Request) context.Context {
+    ctx := context.Background()
+    parts := strings.Split(r.Header.Get("traceparent"), "-")
+    if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+        return ctx
+    }
+    var sc spanContext
+    if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+        return ctx
+    }
+    if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+        return ctx
+    }
+    return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// continueTrace returns ctx continuing the trace of from.
+func continueTrace(ctx, from context.Context) context.Context {
+    if sc, ok := from.Value(spanContextKey{}).(spanContext); ok {
+        return context.WithValue(ctx, spanContextKey{}, sc)
+    }
+    return ctx
+}
+
+// injectTraceContext propagates the current span to an outgoing request.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+    if sc, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+        req.Header.Set("traceparent", fmt.Sprintf("00-%x-%x-01", sc.TraceID, sc.SpanID))
+    }
+}
+
+const (
+    otlpBatchSize     = 256
+    otlpFlushInterval = 5 * time.Second
+    // otlpTimeout limits an export, a hanging collector loses the batch.
+    otlpTimeout = 10 * time.Second
+)
+
+// otlpExporter sends finished spans in batches to an OTLP/HTTP collector
+// using the JSON encoding. Spans are dropped when the collector can't keep up.
+type otlpExporter struct {
+    url    string
+    spans  chan *span
+    client *http.Client
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+    url := strings.TrimSuffix(endpoint, "/")
+    if !strings.HasSuffix(url, "/v1/traces") {
+        url += "/v1/traces"
+    }
+    e := &otlpExporter{url: url, spans: make(chan *span, 4*otlpBatchSize), client: &http.Client{Timeout: otlpTimeout}}
+    go e.run()
+    return e
+}
+
+func (e *otlpExporter) export(s *span) {
+    select {
+    case e.spans <- s:
+    default:
+    }
+}
+
+func (e *otlpExporter) run() {
+    ticker := time.NewTicker(otlpFlushInterval)
+    defer ticker.Stop()
+    batch := make([]*span, 0, otlpBatchSize)
+    for {
+        select {
+        case s := <-e.spans:
+            batch = append(batch, s)
+            if len(batch) < otlpBatchSize {
+                continue
+            }
+        case <-ticker.C:
+            if len(batch) == 0 {
+                continue
+            }
+        }
+        if err := e.send(batch); err != nil {
+            log.Printf("Error exporting %d spans to %s: %v\n", len(batch), e.url, err)
+        }
+        batch = batch[:0]
+    }
+}
+
+type otlpKeyValue struct {
+    Key   string                 `json:"key"`
+    Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+    TraceID           string         `json:"traceId"`
+    SpanID            string         `json:"spanId"`
+    ParentSpanID      string         `json:"parentSpanId,omitempty"`
+    Name              string         `json:"name"`
+    Kind              int            `json:"kind"`
+    StartTimeUnixNano string         `json:"startTimeUnixNano"`
+    EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+    Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+    Status            struct {
+        Code    int    `json:"code,omitempty"`
+        Message string `json:"message,omitempty"`
+    } `json:"status"`
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+    switch v := v.(type) {
+    case int:
+        return map[string]interface{}{"intValue": strconv.Itoa(v)}
+    case int64:
+        return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+    case bool:
+        return map[string]interface{}{"boolValue": v}
+    default:
+        return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+    }
+}
+
+func (e *otlpExporter) send(batch []*span) error {
+    spans := make([]otlpSpan, 0, len(batch))
+    for _, s := range batch {
+        out := otlpSpan{
+            TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+            SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+            Name:              s.name,
+            Kind:              1, // internal
+            StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+            EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+        }
+        if s.parentID != [8]byte{} {
+            out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+        }
+        for _, a := range s.attrs {
+            out.Attributes = append(out.Attributes, otlpKeyValue{a.Key, otlpValue(a.Value)})
+        }
+        if s.err != nil {
+            out.Status.Code = 2 // error
+            out.Status.Message = s.err.Error()
+        }
+        spans = append(spans, out)
+    }
+    payload := map[string]interface{}{
+        "resourceSpans": []interface{}{map[string]interface{}{
+            "resource": map[string]interface{}{
+                "attributes": []otlpKeyValue{{"service.name", otlpValue("feed-monitoring")}},
+            },
+            "scopeSpans": []interface{}{map[string]interface{}{
+                "scope": map[string]string{"name": "feed-monitoring"},
+                "spans": spans,
+            }},
+        }},
+    }
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    res, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    res.Body.Close()
+    if res.StatusCode >= 300 {
+        return fmt.Errorf("got '%v'", res.Status)
+    }
+    return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestOTLPExportTimesOut(t *testing.T) {
+    release := make(chan struct{})
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+    defer srv.Close()
+    defer close(release)
+    e := &otlpExporter{url: srv.URL + "/v1/traces", client: &http.Client{Timeout: 50 * time.Millisecond}}
+    s := &span{name: "check", start: time.Now(), end: time.Now()}
+
+    done := make(chan error, 1)
+    go func() { done <- e.send([]*span{s}) }()
+    select {
+    case err := <-done:
+        if err == nil {
+            t.Error("an export to a hanging collector succeeded")
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("an export to a hanging collector doesn't time out")
+    }
+}